@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/icholy/nick_bot/faceutil"
+)
+
+const commandUsage = "usage: nick_bot [flags] faces build <photo dir> <pack dir>"
+
+// runCommand runs the command named by the arguments after the flags
+func runCommand(d faceutil.Detector, args []string) error {
+	if len(args) == 4 && args[0] == "faces" && args[1] == "build" {
+		return buildFacePack(d, args[2], args[3])
+	}
+	return fmt.Errorf("invalid command: %s\n%s", strings.Join(args, " "), commandUsage)
+}
+
+// buildFacePack crops the faces out of the photos in the source directory
+// and writes them to the destination as a face pack
+func buildFacePack(d faceutil.Detector, srcdir, dstdir string) error {
+	entries, err := ioutil.ReadDir(srcdir)
+	if err != nil {
+		return err
+	}
+	builder := faceutil.NewPackBuilder(d, faceutil.BuildOptions{
+		Margin:      *buildmargin,
+		Size:        *buildsize,
+		MinSize:     *buildmin,
+		MaxDistance: *builddupes,
+		Cutout:      *buildcutout,
+	})
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".jpg", ".jpeg", ".png":
+		default:
+			continue
+		}
+		img, err := faceutil.OpenImage(filepath.Join(srcdir, e.Name()))
+		if err != nil {
+			log.Warnf("build: %s: %s", e.Name(), err)
+			continue
+		}
+		added, dropped := builder.Add(e.Name(), img)
+		log.Infof("build: %s: %d face(s) added, %d duplicate(s) dropped", e.Name(), added, dropped)
+	}
+	if err := builder.Write(dstdir); err != nil {
+		return err
+	}
+	log.Infof("build: wrote %d face(s) to %s", builder.Len(), dstdir)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/icholy/nick_bot/faceutil"
+)
+
+// evalDetector compares the detector's output on the images in dir to the
+// ground truth and prints the images with mistakes followed by the totals.
+func evalDetector(r *faceutil.Renderer, dir, truthfile string, iou float64, w io.Writer) error {
+	annotations, err := faceutil.LoadAnnotations(truthfile)
+	if err != nil {
+		return err
+	}
+	e, err := faceutil.Evaluate(r, dir, annotations, iou)
+	if err != nil {
+		return err
+	}
+	for _, img := range e.Images {
+		if len(img.Misses) == 0 && len(img.FalsePositives) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s: found %d/%d faces", img.Image, img.Truth-len(img.Misses), img.Truth)
+		for _, r := range img.Misses {
+			fmt.Fprintf(w, ", missed %v", r)
+		}
+		for _, r := range img.FalsePositives {
+			fmt.Fprintf(w, ", false positive %v", r)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d images, %s\n", len(e.Images), formatEval(e))
+	return nil
+}
+
+// sweepParam is a flag and the values to try it with
+type sweepParam struct {
+	name   string
+	values []string
+}
+
+// parseSweep parses a sweep like "min.neighboor=3,5,9;haar.scale=1.05,1.1"
+func parseSweep(s string) ([]sweepParam, error) {
+	var params []sweepParam
+	for _, field := range strings.Split(s, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid sweep parameter: %s", field)
+		}
+		name := strings.TrimSpace(parts[0])
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("invalid sweep flag: %s", name)
+		}
+		params = append(params, sweepParam{
+			name:   name,
+			values: strings.Split(parts[1], ","),
+		})
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("empty sweep: %q", s)
+	}
+	return params, nil
+}
+
+// sweepDetector evaluates the detector with every combination of the flag
+// values in the sweep and prints the best configuration.
+func sweepDetector(faces *faceutil.FacePack, eyes faceutil.Detector, dir, truthfile, sweep string, iou float64, w io.Writer) error {
+	params, err := parseSweep(sweep)
+	if err != nil {
+		return err
+	}
+	annotations, err := faceutil.LoadAnnotations(truthfile)
+	if err != nil {
+		return err
+	}
+	var (
+		best     string
+		bestEval *faceutil.Evaluation
+		config   = make([]string, len(params))
+	)
+	var sweepFrom func(i int) error
+	sweepFrom = func(i int) error {
+		if i < len(params) {
+			for _, value := range params[i].values {
+				value = strings.TrimSpace(value)
+				if err := flag.Set(params[i].name, value); err != nil {
+					return fmt.Errorf("-%s=%s: %s", params[i].name, value, err)
+				}
+				config[i] = fmt.Sprintf("-%s=%s", params[i].name, value)
+				if err := sweepFrom(i + 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		d, err := loadDetector()
+		if err != nil {
+			return err
+		}
+		if c, ok := d.(io.Closer); ok {
+			defer c.Close()
+		}
+		r, err := faceutil.NewRenderer(renderOptions(d, faces, eyes, nil))
+		if err != nil {
+			return err
+		}
+		e, err := faceutil.Evaluate(r, dir, annotations, iou)
+		if err != nil {
+			return err
+		}
+		line := strings.Join(config, " ")
+		fmt.Fprintf(w, "%s: %s\n", line, formatEval(e))
+		// ties are broken by how well the faces line up
+		if bestEval == nil || e.F1() > bestEval.F1() ||
+			e.F1() == bestEval.F1() && e.MeanIoU() > bestEval.MeanIoU() {
+			best, bestEval = line, e
+		}
+		return nil
+	}
+	if err := sweepFrom(0); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "best: %s: %s\n", best, formatEval(bestEval))
+	return nil
+}
+
+func formatEval(e *faceutil.Evaluation) string {
+	return fmt.Sprintf("precision %.3f, recall %.3f, f1 %.3f, mean iou %.3f",
+		e.Precision(), e.Recall(), e.F1(), e.MeanIoU())
+}
@@ -0,0 +1,121 @@
+package facebot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/icholy/nick_bot/faceutil"
+	"github.com/icholy/nick_bot/instagram"
+	"github.com/icholy/nick_bot/model"
+)
+
+// PostCollage posts a grid of related photos as a single photo
+func (b *Bot) PostCollage() error {
+
+	// find the related images
+	recs, err := b.store.SearchGroup(b.opt.MinFaces, b.opt.CollageCount, b.opt.CollageGroup)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		log.Infof("bot: posting %s", rec)
+	}
+
+	// try to post them
+	state := model.MediaUsed
+	if err := b.postCollageRecords(recs); err != nil {
+		log.Errorf("bot: %s", err)
+		state = model.MediaRejected
+	}
+	for _, rec := range recs {
+		if err := b.store.SetState(rec.ID, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderRecords renders the records without using a face twice
+func (b *Bot) renderRecords(recs []*model.Record) ([]*rendered, error) {
+	selector, err := b.newSelector()
+	if err != nil {
+		return nil, err
+	}
+	var rr []*rendered
+	for _, rec := range recs {
+		r, err := b.renderRecord(rec, selector)
+		if err != nil {
+			return nil, err
+		}
+		rr = append(rr, r)
+	}
+	return rr, nil
+}
+
+func (b *Bot) putGroupFaceUsage(recs []*model.Record, rr []*rendered) error {
+	for i, rec := range recs {
+		if err := b.store.PutFaceUsage(rec.ID, rr[i].used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bot) postCollageRecords(recs []*model.Record) error {
+	rr, err := b.renderRecords(recs)
+	if err != nil {
+		return err
+	}
+	photos := make([]faceutil.CollagePhoto, len(rr))
+	for i, r := range rr {
+		photos[i] = faceutil.CollagePhoto{Image: r.image, Faces: r.faces}
+	}
+	collage := b.renderer.Collage(photos, b.opt.Collage)
+
+	// save image
+	imgpath := filepath.Join("output", recs[0].ID+"_collage.jpeg")
+	log.Infof("bot: writing collage %s", imgpath)
+	if err := exportImage(imgpath, b.renderer, collage, nil, b.opt.Export); err != nil {
+		return err
+	}
+
+	if !b.opt.Upload {
+		return b.putGroupFaceUsage(recs, rr)
+	}
+
+	// upload photo
+	log.Infof("bot: uploading collage")
+	session, err := instagram.NewSession(b.opt.Username, b.opt.Password)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err := session.UploadPhoto(imgpath, b.getGroupCaption(recs)); err != nil {
+		return err
+	}
+	return b.putGroupFaceUsage(recs, rr)
+}
+
+// getGroupCaption is the caption for a post made from several records.
+// Every original poster is credited once.
+func (b *Bot) getGroupCaption(recs []*model.Record) string {
+	var (
+		names []string
+		seen  = map[string]bool{}
+	)
+	for _, rec := range recs {
+		if !seen[rec.Username] {
+			seen[rec.Username] = true
+			names = append(names, "@"+rec.Username)
+		}
+	}
+	credit := fmt.Sprintf("photocred goes to: %s", strings.Join(names, " "))
+	caption := b.nextCaption()
+	if caption == "" {
+		return credit
+	}
+	return fmt.Sprintf("%s\n\n%s", caption, credit)
+}
@@ -3,12 +3,12 @@ package facebot
 import (
 	"fmt"
 	"image"
+	"io"
 	"math/rand"
 	"path/filepath"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/icholy/nick_bot/faceutil"
 	"github.com/icholy/nick_bot/imgstore"
@@ -24,13 +24,36 @@ type Options struct {
 	AutoFollow bool
 	Captions   []string
 	Store      *imgstore.Store
+	Renderer   *faceutil.Renderer
+	Export     faceutil.ExportOptions
+
+	// Collage is the layout of collages. CollageCount records
+	// related by CollageGroup are used in each collage.
+	Collage      faceutil.CollageOptions
+	CollageCount int
+	CollageGroup imgstore.Grouping
+
+	// Story is the layout of stories. The caption is only added
+	// when StoryCaption is set.
+	Story        faceutil.StoryOptions
+	StoryCaption bool
+
+	// DrawText draws the caption on photo posts as well using
+	// Export.Text
+	DrawText bool
+
+	// FaceHistory is the number of recent posts whose
+	// faces are less likely to be used again
+	FaceHistory int
 }
 
 type Bot struct {
-	opt   *Options
-	store *imgstore.Store
+	opt      *Options
+	store    *imgstore.Store
+	renderer *faceutil.Renderer
 
-	captionIndex int
+	// captionIndex is shared with the bots made by WithPolicy
+	captionIndex *int
 }
 
 func New(o *Options) *Bot {
@@ -38,23 +61,46 @@ func New(o *Options) *Bot {
 		o.MinFaces = 1
 	}
 	return &Bot{
-		opt:   o,
-		store: o.Store,
+		opt:          o,
+		store:        o.Store,
+		renderer:     o.Renderer,
+		captionIndex: new(int),
+	}
+}
+
+// WithPolicy returns a bot which replaces the faces in its posts using
+// the policy
+func (b *Bot) WithPolicy(p faceutil.Policy) *Bot {
+	c := *b
+	c.renderer = b.renderer.WithPolicy(p)
+	return &c
+}
+
+func (b *Bot) getCaption(caption string, rec *model.Record) string {
+	credit := getCredit(rec)
+	if caption == "" {
+		return credit
 	}
+	return fmt.Sprintf("%s\n\n%s", caption, credit)
 }
 
-func (b *Bot) getCaption(rec *model.Record) string {
-	credit := fmt.Sprintf("photocred goes to: @%s", rec.Username)
+func getCredit(rec *model.Record) string {
+	return fmt.Sprintf("photocred goes to: @%s", rec.Username)
+}
+
+// nextCaption returns the next caption or an empty string
+// when there aren't any
+func (b *Bot) nextCaption() string {
 	captions := b.opt.Captions
 	if len(captions) == 0 {
-		return credit
+		return ""
 	}
-	caption := captions[b.captionIndex]
-	b.captionIndex++
-	if b.captionIndex >= len(captions) {
-		b.captionIndex = 0
+	caption := captions[*b.captionIndex]
+	*b.captionIndex++
+	if *b.captionIndex >= len(captions) {
+		*b.captionIndex = 0
 	}
-	return fmt.Sprintf("%s\n\n%s", caption, credit)
+	return caption
 }
 
 func (b *Bot) Run() {
@@ -88,7 +134,7 @@ func (b *Bot) handleNewMedia(m *model.Media) error {
 	}
 
 	// find the faces
-	faces := faceutil.DetectFaces(img)
+	faces, _ := b.renderer.Detect(img)
 
 	// write to store
 	return b.store.Put(&model.Record{
@@ -103,6 +149,15 @@ func (b *Bot) handleExistingMedia(m *model.Media) error {
 }
 
 func (b *Bot) Post() error {
+	return b.post(b.postRecord)
+}
+
+// PostStory posts a photo to the story instead of the feed
+func (b *Bot) PostStory() error {
+	return b.post(b.postStoryRecord)
+}
+
+func (b *Bot) post(postRecord func(*model.Record) error) error {
 
 	// find the best image
 	rec, err := b.store.SearchRandom(b.opt.MinFaces)
@@ -112,7 +167,7 @@ func (b *Bot) Post() error {
 	log.Infof("bot: posting %s", rec)
 
 	// try to post it
-	if err := b.postRecord(rec); err != nil {
+	if err := postRecord(rec); err != nil {
 		log.Errorf("bot: %s", err)
 		return b.store.SetState(rec.ID, model.MediaRejected)
 	} else {
@@ -121,41 +176,102 @@ func (b *Bot) Post() error {
 }
 
 func (b *Bot) Demo() (image.Image, error) {
+	img, err := b.demoImage()
+	if err != nil {
+		return nil, err
+	}
+	newImage := b.renderer.Replace(img)
+	return newImage, nil
+}
+
+// DemoGIF writes a before and after animation of a random photo
+func (b *Bot) DemoGIF(w io.Writer, opt faceutil.GIFOptions) error {
+	img, err := b.demoImage()
+	if err != nil {
+		return err
+	}
+	return b.renderer.WriteGIF(w, img, opt)
+}
+
+func (b *Bot) demoImage() (image.Image, error) {
 	rec, err := b.store.SearchRandom(b.opt.MinFaces)
 	if err != nil {
 		return nil, err
 	}
-	img, err := fetchImage(rec.URL)
+	return fetchImage(rec.URL)
+}
+
+// rendered is a record's photo with the faces replaced
+type rendered struct {
+	image image.Image
+	faces []faceutil.Detection
+
+	// used is the names of the faces drawn on the photo
+	used []string
+}
+
+// newSelector returns a selector which avoids the faces used in recent posts
+func (b *Bot) newSelector() (*faceutil.FaceSelector, error) {
+	recent, err := b.store.RecentFaceUsage(b.opt.FaceHistory)
 	if err != nil {
 		return nil, err
 	}
-	newImage := faceutil.ReplaceFaces(img)
-	return newImage, nil
+	return b.renderer.NewSelector(recent), nil
 }
 
-func (b *Bot) postRecord(rec *model.Record) error {
+// renderRecord downloads the record's photo and replaces the faces with
+// ones picked by the selector. A nil selector uses a new one.
+func (b *Bot) renderRecord(rec *model.Record, selector *faceutil.FaceSelector) (*rendered, error) {
 
 	// download image
 	img, err := fetchImage(rec.URL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// replace the faces
-	newImage := faceutil.ReplaceFaces(img)
+	// replace the faces, avoiding the ones used in recent posts
+	if selector == nil {
+		if selector, err = b.newSelector(); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		renderer        = b.renderer
+		used            = len(selector.Used())
+		faces, rejected = renderer.Detect(img)
+		newImage        = renderer.DrawRejections(renderer.DrawWith(selector, img, faces), rejected)
+	)
+	return &rendered{
+		image: newImage,
+		faces: faces,
+		used:  selector.Used()[used:],
+	}, nil
+}
+
+func (b *Bot) postRecord(rec *model.Record) error {
+	r, err := b.renderRecord(rec, nil)
 	if err != nil {
 		return err
 	}
 
+	// the caption is picked before exporting so it can be drawn on the photo
+	var (
+		caption = b.nextCaption()
+		export  = b.opt.Export
+	)
+	if b.opt.DrawText {
+		export.Caption = caption
+	}
+
 	// save image
 	imgpath := filepath.Join("output", rec.ID+".jpeg")
 	log.Infof("bot: writing image %s", imgpath)
-	if err := writeImage(imgpath, newImage); err != nil {
+	if err := exportImage(imgpath, b.renderer, r.image, r.faces, export); err != nil {
 		return err
 	}
 
 	if !b.opt.Upload {
-		return nil
+		return b.store.PutFaceUsage(rec.ID, r.used)
 	}
 
 	// upload photo
@@ -165,8 +281,10 @@ func (b *Bot) postRecord(rec *model.Record) error {
 		return err
 	}
 	defer session.Close()
-	caption := b.getCaption(rec)
-	if err := session.UploadPhoto(imgpath, caption); err != nil {
+	if err := session.UploadPhoto(imgpath, b.getCaption(caption, rec)); err != nil {
+		return err
+	}
+	if err := b.store.PutFaceUsage(rec.ID, r.used); err != nil {
 		return err
 	}
 
@@ -176,6 +294,48 @@ func (b *Bot) postRecord(rec *model.Record) error {
 	return nil
 }
 
+func (b *Bot) postStoryRecord(rec *model.Record) error {
+	r, err := b.renderRecord(rec, nil)
+	if err != nil {
+		return err
+	}
+
+	// lay the photo out as a story
+	opt := b.opt.Story
+	opt.Credit = getCredit(rec)
+	if b.opt.StoryCaption {
+		opt.Caption = b.nextCaption()
+	}
+	story := faceutil.Story(r.image, opt)
+
+	// the story is already the right shape, so only the size is limited
+	export := b.opt.Export
+	export.MinAspect, export.MaxAspect, export.Width = 0, 0, 0
+
+	// save image
+	imgpath := filepath.Join("output", rec.ID+"_story.jpeg")
+	log.Infof("bot: writing story %s", imgpath)
+	if err := exportImage(imgpath, b.renderer, story, nil, export); err != nil {
+		return err
+	}
+
+	if !b.opt.Upload {
+		return b.store.PutFaceUsage(rec.ID, r.used)
+	}
+
+	// upload story
+	log.Infof("bot: uploading story")
+	session, err := instagram.NewSession(b.opt.Username, b.opt.Password)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err := session.UploadStory(imgpath); err != nil {
+		return err
+	}
+	return b.store.PutFaceUsage(rec.ID, r.used)
+}
+
 func (b *Bot) followRandom(s *instagram.Session, userID int64) error {
 	users, err := s.GetFollowers(userID)
 	if err != nil {
@@ -2,19 +2,24 @@ package facebot
 
 import (
 	"image"
-	"image/jpeg"
 	_ "image/png"
 	"net/http"
 	"os"
+
+	"github.com/icholy/nick_bot/faceutil"
 )
 
-func writeImage(filename string, img image.Image) error {
+// exportImage writes the rendered image to filename in a form which can be uploaded
+func exportImage(filename string, r *faceutil.Renderer, img image.Image, faces []faceutil.Detection, opt faceutil.ExportOptions) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return jpeg.Encode(f, img, &jpeg.Options{jpeg.DefaultQuality})
+	if err := r.Export(f, img, faces, opt); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 func fetchImage(url string) (image.Image, error) {
@@ -23,7 +28,7 @@ func fetchImage(url string) (image.Image, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	img, _, err := image.Decode(resp.Body)
+	img, err := faceutil.DecodeImage(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,315 @@
+package faceutil
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/disintegration/imaging"
+)
+
+// Anchor is the point on a detected face an accessory is attached to
+type Anchor int
+
+const (
+	AnchorHead  Anchor = iota // top of the head
+	AnchorEyes                // eye line
+	AnchorMouth               // mouth
+)
+
+func (a Anchor) String() string {
+	switch a {
+	case AnchorHead:
+		return "head"
+	case AnchorEyes:
+		return "eyes"
+	case AnchorMouth:
+		return "mouth"
+	default:
+		return "invalid"
+	}
+}
+
+// ParseAnchor parses an anchor name (head, eyes, or mouth)
+func ParseAnchor(s string) (Anchor, error) {
+	switch s {
+	case "head":
+		return AnchorHead, nil
+	case "eyes":
+		return AnchorEyes, nil
+	case "mouth":
+		return AnchorMouth, nil
+	default:
+		return 0, fmt.Errorf("invalid anchor: %s", s)
+	}
+}
+
+// point returns the anchor's position in the upright face rect. The
+// positions are where they usually are inside the detected rects.
+func (a Anchor) point(rect image.Rectangle) pointF {
+	var y float64
+	switch a {
+	case AnchorEyes:
+		y = 0.4
+	case AnchorMouth:
+		y = 0.78
+	}
+	return pointF{
+		x: float64(rect.Min.X) + float64(rect.Dx())*0.5,
+		y: float64(rect.Min.Y) + float64(rect.Dy())*y,
+	}
+}
+
+// accessory is an image which is attached to an anchor on detected faces
+type accessory struct {
+	name   string
+	img    *image.NRGBA
+	anchor Anchor
+
+	// point is the pixel in the image which is placed on the anchor
+	point image.Point
+
+	// width is the accessory's width as a fraction of the face's width
+	width float64
+
+	weight float64
+}
+
+// AccessoryPack is a set of accessories which can be drawn on detected faces
+type AccessoryPack struct {
+	Name        string
+	accessories []accessory
+}
+
+// accessoryManifest describes the accessories in an accessory pack.
+//
+//	{
+//	  "name": "nick",
+//	  "accessories": [
+//	    {
+//	      "file": "hat.png",
+//	      "anchor": "head",
+//	      "point": [128, 150],
+//	      "width": 1.3,
+//	      "weight": 2
+//	    }
+//	  ]
+//	}
+//
+// The file and anchor are required. The point defaults to the center of
+// the image and the width and weight default to 1.
+type accessoryManifest struct {
+	Name        string              `json:"name"`
+	Accessories []manifestAccessory `json:"accessories"`
+}
+
+type manifestAccessory struct {
+	File   string   `json:"file"`
+	Anchor string   `json:"anchor"`
+	Point  *[2]int  `json:"point"`
+	Width  float64  `json:"width"`
+	Weight *float64 `json:"weight"`
+}
+
+// LoadAccessoryPack loads an accessory pack from a zip archive or a
+// directory with a manifest.json file
+func LoadAccessoryPack(path string) (*AccessoryPack, error) {
+	var (
+		pack *AccessoryPack
+		err  error
+	)
+	if strings.HasSuffix(path, ".zip") {
+		pack, err = loadZipAccessories(path)
+	} else {
+		pack, err = loadAccessoryManifest(path, openDir(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pack.accessories) == 0 {
+		return nil, fmt.Errorf("no accessories in %s", path)
+	}
+	return pack, nil
+}
+
+func MustLoadAccessoryPack(path string) *AccessoryPack {
+	pack, err := LoadAccessoryPack(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pack
+}
+
+func loadZipAccessories(file string) (*AccessoryPack, error) {
+	r, open, err := openZip(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return loadAccessoryManifest(file, open)
+}
+
+// loadAccessoryManifest reads the manifest and the images it lists
+func loadAccessoryManifest(source string, open openFunc) (*AccessoryPack, error) {
+	var m accessoryManifest
+	if err := decodeJSON(open, manifestName, &m); err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+	pack := &AccessoryPack{Name: m.Name}
+	if pack.Name == "" {
+		pack.Name = filepath.Base(source)
+	}
+	for _, ma := range m.Accessories {
+		a, err := loadManifestAccessory(open, ma)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", source, ma.File, err)
+		}
+		pack.accessories = append(pack.accessories, a)
+	}
+	return pack, nil
+}
+
+func loadManifestAccessory(open openFunc, ma manifestAccessory) (accessory, error) {
+	a := accessory{
+		name:   ma.File,
+		width:  ma.Width,
+		weight: 1,
+	}
+	if ma.File == "" {
+		return a, errors.New("missing file")
+	}
+	if ma.Anchor == "" {
+		return a, errors.New("missing anchor")
+	}
+	var err error
+	if a.anchor, err = ParseAnchor(ma.Anchor); err != nil {
+		return a, err
+	}
+	if a.width < 0 {
+		return a, fmt.Errorf("invalid width: %g", a.width)
+	}
+	if a.width == 0 {
+		a.width = 1
+	}
+	if ma.Weight != nil {
+		if *ma.Weight < 0 {
+			return a, fmt.Errorf("invalid weight: %g", *ma.Weight)
+		}
+		a.weight = *ma.Weight
+	}
+	r, err := open(ma.File)
+	if err != nil {
+		return a, err
+	}
+	defer r.Close()
+	if a.img, err = DecodeImage(r); err != nil {
+		return a, err
+	}
+	if ma.Point != nil {
+		a.point = image.Pt(ma.Point[0], ma.Point[1])
+	} else {
+		a.point = getRectCenter(a.img.Rect)
+	}
+	return a, nil
+}
+
+// pick picks up to count random accessories on different anchors with
+// the probability of each accessory proportional to its weight
+func (p *AccessoryPack) pick(rnd *rand.Rand, count int) []*accessory {
+	var (
+		picked []*accessory
+		used   = map[Anchor]bool{}
+	)
+	for len(picked) < count {
+		var (
+			choices []*accessory
+			total   float64
+		)
+		for i := range p.accessories {
+			a := &p.accessories[i]
+			if !used[a.anchor] && a.weight > 0 {
+				choices = append(choices, a)
+				total += a.weight
+			}
+		}
+		if len(choices) == 0 {
+			break
+		}
+		choice := choices[len(choices)-1]
+		r := rnd.Float64() * total
+		for _, a := range choices {
+			if r < a.weight {
+				choice = a
+				break
+			}
+			r -= a.weight
+		}
+		used[choice.anchor] = true
+		picked = append(picked, choice)
+	}
+	return picked
+}
+
+// AccessoryMode draws random accessories from the pack on the face. Count
+// is the number of accessories on each face, each on a different anchor.
+// Zero draws one. Stack it with OverlayMode to draw them on the new face.
+type AccessoryMode struct {
+	Pack  *AccessoryPack
+	Count int
+}
+
+func (m AccessoryMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	return m.fix(r, s, group, face.Pose, face.Rect.Dx()).Replace(r, s, canvas, face, group)
+}
+
+func (m AccessoryMode) fix(r *Renderer, s *FaceSelector, group bool, pose Pose, width int) ReplaceMode {
+	return accessorySet(m.Pack.pick(r.rand, maxInt(m.Count, 1)))
+}
+
+// accessorySet draws the accessories picked by AccessoryMode
+type accessorySet []*accessory
+
+func (set accessorySet) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	for _, a := range set {
+		canvas = drawAccessory(canvas, face, a)
+	}
+	return canvas
+}
+
+// drawAccessory scales the accessory to the face's width and draws it with
+// its point on the face's anchor. The accessory is tilted with the face.
+func drawAccessory(canvas *image.NRGBA, face Detection, a *accessory) *image.NRGBA {
+	var (
+		rect   = face.Rect
+		width  = maxInt(round(a.width*float64(rect.Dx())), 1)
+		img    = imaging.Resize(a.img, width, 0, imaging.Lanczos)
+		scale  = float64(width) / float64(a.img.Rect.Dx())
+		anchor = a.anchor.point(rect)
+		point  = pointF{
+			x: float64(a.point.X-a.img.Rect.Min.X) * scale,
+			y: float64(a.point.Y-a.img.Rect.Min.Y) * scale,
+		}
+	)
+	if face.Angle != 0 {
+		// rotating expands the image around its center, so the point
+		// is rotated around the center too. The anchor is rotated
+		// around the face's center.
+		var (
+			before = rectCenterF(img.Rect)
+			px, py = rotateF(point.x-before.x, point.y-before.y, face.Angle)
+			c      = rectCenterF(rect)
+			ax, ay = rotateF(anchor.x-c.x, anchor.y-c.y, face.Angle)
+		)
+		img = imaging.Rotate(img, face.Angle, color.Transparent)
+		after := rectCenterF(img.Rect)
+		point = pointF{x: after.x + px, y: after.y + py}
+		anchor = pointF{x: c.x + ax, y: c.y + ay}
+	}
+	min := image.Pt(round(anchor.x-point.x), round(anchor.y-point.y))
+	return imaging.Overlay(canvas, img, min, 1)
+}
@@ -0,0 +1,189 @@
+package faceutil
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Eyes are the centers of a face's eyes. Left is the eye nearest
+// the left edge of the image.
+type Eyes struct {
+	Left  image.Point
+	Right image.Point
+}
+
+// locateEyes looks for a pair of eyes in the top part of the face.
+// Tilted faces are rotated upright before looking. It always fails
+// when the eye detector is nil.
+func locateEyes(eyeDetector Detector, img image.Image, face Detection) (Eyes, bool) {
+	if eyeDetector == nil {
+		return Eyes{}, false
+	}
+	var (
+		w       = face.Rect.Dx()
+		h       = face.Rect.Dy()
+		center  = getRectCenter(face.Rect)
+		upright image.Image
+	)
+	if face.Angle == 0 {
+		upright = imaging.Crop(img, face.Rect)
+	} else {
+		// crop enough around the face to cover it at any angle
+		// and then rotate it back to upright.
+		var (
+			size    = int(math.Hypot(float64(w), float64(h))) + 2
+			around  = getRectCenteredAt(image.Rect(0, 0, size, size), center)
+			rotated = imaging.Rotate(imaging.Crop(img, around), -face.Angle, color.Transparent)
+		)
+		c := getRectCenter(rotated.Rect)
+		upright = imaging.Crop(rotated, image.Rect(c.X-w/2, c.Y-h/2, c.X-w/2+w, c.Y-h/2+h))
+	}
+	bounds := upright.Bounds()
+	if bounds.Dx() != w || bounds.Dy() != h {
+		// the face is partly outside the image
+		return Eyes{}, false
+	}
+
+	// only the top of the face is searched so nostrils and mouths
+	// aren't mistaken for eyes.
+	top := imaging.Crop(upright, image.Rect(0, 0, w, h*3/5))
+
+	var (
+		found bool
+		best  float64
+		eyes  Eyes
+		hits  = eyeDetector.Detect(top)
+	)
+	for i, a := range hits {
+		for _, b := range hits[i+1:] {
+			left, right := getRectCenter(a.Rect), getRectCenter(b.Rect)
+			if left.X > right.X {
+				left, right = right, left
+			}
+			// the eyes should be on either side of the middle of the face,
+			// far enough apart and roughly level.
+			if left.X >= w/2 || right.X <= w/2 {
+				continue
+			}
+			if right.X-left.X < w/4 || absInt(right.Y-left.Y) > w/5 {
+				continue
+			}
+			if score := a.Score + b.Score; !found || score > best {
+				found, best = true, score
+				eyes = Eyes{Left: left, Right: right}
+			}
+		}
+	}
+	if !found {
+		return Eyes{}, false
+	}
+
+	// map the eyes from the upright crop back onto the image
+	origin := center.Sub(image.Pt(w/2, h/2))
+	eyes.Left = rotatePoint(eyes.Left.Add(origin), center, face.Angle)
+	eyes.Right = rotatePoint(eyes.Right.Add(origin), center, face.Angle)
+	return eyes, true
+}
+
+// alignFace scales, rotates, and positions the face image so that its
+// eyes land on the target eyes. It returns the transformed face and
+// the rectangle it should be drawn in.
+func alignFace(face faceImage, target Eyes) (*image.NRGBA, image.Rectangle) {
+	var (
+		b     = face.img.Bounds()
+		src   = *face.eyes
+		scale = eyeDistance(target) / eyeDistance(src)
+		angle = eyeAngle(target) - eyeAngle(src)
+
+		faceImg = imaging.Resize(face.img, maxInt(round(float64(b.Dx())*scale), 1), 0, imaging.Lanczos)
+
+		// midpoint between the face image's eyes after resizing
+		sx  = float64(faceImg.Rect.Dx()) / float64(b.Dx())
+		sy  = float64(faceImg.Rect.Dy()) / float64(b.Dy())
+		mid = pointF{
+			x: (float64(src.Left.X+src.Right.X)/2 - float64(b.Min.X)) * sx,
+			y: (float64(src.Left.Y+src.Right.Y)/2 - float64(b.Min.Y)) * sy,
+		}
+	)
+	if angle != 0 {
+		// rotating expands the image around its center, so the
+		// midpoint is rotated around the center too.
+		var (
+			before = rectCenterF(faceImg.Rect)
+			x, y   = rotateF(mid.x-before.x, mid.y-before.y, angle)
+		)
+		faceImg = imaging.Rotate(faceImg, angle, color.Transparent)
+		after := rectCenterF(faceImg.Rect)
+		mid = pointF{x: after.x + x, y: after.y + y}
+	}
+	var (
+		targetX = float64(target.Left.X+target.Right.X) / 2
+		targetY = float64(target.Left.Y+target.Right.Y) / 2
+		min     = image.Pt(round(targetX-mid.x), round(targetY-mid.y))
+	)
+	return faceImg, faceImg.Rect.Add(min)
+}
+
+func eyeDistance(e Eyes) float64 {
+	return math.Max(math.Hypot(float64(e.Right.X-e.Left.X), float64(e.Right.Y-e.Left.Y)), 1)
+}
+
+// eyeAngle is the angle of the line from the left eye to the right eye
+// in degrees counter-clockwise.
+func eyeAngle(e Eyes) float64 {
+	return math.Atan2(float64(e.Left.Y-e.Right.Y), float64(e.Right.X-e.Left.X)) * 180 / math.Pi
+}
+
+// flipEyes mirrors the eyes for an image that's been flipped horizontally
+// with imaging.FlipH
+func flipEyes(e Eyes, b image.Rectangle) Eyes {
+	flip := func(p image.Point) image.Point {
+		return image.Pt(b.Max.X-1-p.X, p.Y-b.Min.Y)
+	}
+	return Eyes{
+		Left:  flip(e.Right),
+		Right: flip(e.Left),
+	}
+}
+
+// loadEyeAnnotations reads the eyes.txt file in a face directory.
+// Each line has a face image name followed by the left and right
+// eye coordinates:
+//
+//	face_0.png 180 430 420 410
+//
+// Missing files are ignored.
+func loadEyeAnnotations(file string) (map[string]Eyes, error) {
+	annotations := map[string]Eyes{}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return annotations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var (
+			name string
+			eyes Eyes
+		)
+		if _, err := fmt.Sscan(line, &name, &eyes.Left.X, &eyes.Left.Y, &eyes.Right.X, &eyes.Right.Y); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		annotations[name] = eyes
+	}
+	return annotations, scanner.Err()
+}
@@ -0,0 +1,169 @@
+package faceutil
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// Animation is an animated GIF with every frame composited
+// into a complete picture
+type Animation struct {
+	Frames []*image.NRGBA
+
+	// Delays are the times between the frames in 100ths of a second
+	Delays    []int
+	LoopCount int
+}
+
+// DecodeAnimation decodes all the frames of a GIF. Each frame is drawn
+// over the previous ones following the GIF's disposal methods.
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+	var (
+		a = &Animation{
+			Delays:    g.Delay,
+			LoopCount: g.LoopCount,
+		}
+		canvas = image.NewNRGBA(bounds)
+	)
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		a.Frames = append(a.Frames, imaging.Clone(canvas))
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a, nil
+}
+
+func OpenAnimation(file string) (*Animation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeAnimation(f)
+}
+
+// EncodeAnimation writes the animation as a GIF. Each frame gets its own
+// palette and transparent pixels are kept.
+func EncodeAnimation(w io.Writer, a *Animation) error {
+	g := &gif.GIF{
+		Delay:     a.Delays,
+		LoopCount: a.LoopCount,
+	}
+	for _, frame := range a.Frames {
+		// every frame is complete, so the previous one is cleared first
+		g.Image = append(g.Image, quantizeFrame(frame))
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// quantizeFrame dithers the frame with a palette made from its colors.
+// Mostly transparent pixels get a transparent palette entry.
+func quantizeFrame(frame *image.NRGBA) *image.Paletted {
+	var transparent bool
+	for i := 3; i < len(frame.Pix); i += 4 {
+		if frame.Pix[i] < 128 {
+			transparent = true
+			break
+		}
+	}
+	colors := 256
+	if transparent {
+		colors--
+	}
+	palette := medianCut([]*image.NRGBA{frame}, colors)
+	if transparent {
+		palette = append(palette, color.Transparent)
+	}
+	p := image.NewPaletted(frame.Rect, palette)
+	draw.FloydSteinberg.Draw(p, frame.Rect, frame, frame.Rect.Min)
+	if transparent {
+		index := uint8(len(palette) - 1)
+		b := frame.Rect
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if frame.Pix[frame.PixOffset(x, y)+3] < 128 {
+					p.SetColorIndex(x, y, index)
+				}
+			}
+		}
+	}
+	return p
+}
+
+// ReplaceAnimation replaces the faces in every frame of the animation. The
+// faces are tracked across the frames so each person keeps the same face
+// and mode. The policy and modes use the median width of each track.
+func (r *Renderer) ReplaceAnimation(a *Animation, opt TrackOptions) *Animation {
+	var (
+		detections = make([][]Detection, len(a.Frames))
+		rejections = make([][]Rejection, len(a.Frames))
+		count      int
+	)
+	for i, frame := range a.Frames {
+		detections[i], rejections[i] = r.Detect(frame)
+		count = maxInt(count, len(detections[i]))
+	}
+	var (
+		s      = r.NewSelector(nil)
+		frames = make([]*image.NRGBA, len(a.Frames))
+	)
+	for i, frame := range a.Frames {
+		frames[i] = imaging.Clone(frame)
+	}
+	var (
+		tracks  = TrackFaces(detections, opt)
+		medians = make([]Detection, len(tracks))
+	)
+	for i, t := range tracks {
+		medians[i] = Detection{Rect: image.Rect(0, 0, t.Width(), t.Width())}
+	}
+	modes, group := r.faceModes(medians, count)
+	for i, t := range tracks {
+		// make the random choices once for the whole track
+		mode := fixMode(modes[i], r, s, group[i], t.Pose(), t.Width())
+		for j, face := range t.Faces {
+			f := t.Start + j
+			frames[f] = r.replaceFace(s, frames[f], face, mode, group[i])
+		}
+	}
+	for i := range frames {
+		frames[i] = r.DrawRejections(frames[i], rejections[i])
+	}
+	return &Animation{
+		Frames:    frames,
+		Delays:    a.Delays,
+		LoopCount: a.LoopCount,
+	}
+}
@@ -0,0 +1,265 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// BlendMode is how a face image is combined with the photo
+type BlendMode int
+
+const (
+	// BlendOverlay draws the face as it is
+	BlendOverlay BlendMode = iota
+
+	// BlendFeather fades the face out towards the edges of an ellipse
+	// which fills the placement rect.
+	BlendFeather
+
+	// BlendSeamless keeps the details of the face but takes its colors
+	// from the photo around it using Poisson image editing.
+	BlendSeamless
+)
+
+// BlendOptions configure how faces are blended into the photo
+type BlendOptions struct {
+	Mode BlendMode
+
+	// Feather is the width of the feathered edge as a fraction
+	// of the face.
+	Feather float64
+
+	// Iterations is the number of iterations used to solve
+	// the seamless blend.
+	Iterations int
+}
+
+func (m BlendMode) String() string {
+	switch m {
+	case BlendOverlay:
+		return "overlay"
+	case BlendFeather:
+		return "feather"
+	case BlendSeamless:
+		return "seamless"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (m *BlendMode) Set(s string) error {
+	mode, err := ParseBlendMode(s)
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+func ParseBlendMode(s string) (BlendMode, error) {
+	switch s {
+	case "overlay":
+		return BlendOverlay, nil
+	case "feather":
+		return BlendFeather, nil
+	case "seamless":
+		return BlendSeamless, nil
+	default:
+		return 0, fmt.Errorf("invalid blend mode: %s", s)
+	}
+}
+
+// Blend draws the face onto the canvas with its top left corner at pt
+func Blend(canvas *image.NRGBA, face *image.NRGBA, pt image.Point, opt BlendOptions, opacity float64) *image.NRGBA {
+	switch opt.Mode {
+	case BlendFeather:
+		return imaging.Overlay(canvas, featherMask(face, opt.Feather), pt, opacity)
+	case BlendSeamless:
+		return seamlessClone(canvas, face, pt, opt.Iterations, opacity)
+	default:
+		return imaging.Overlay(canvas, face, pt, opacity)
+	}
+}
+
+// featherMask returns a copy of the face which fades out towards the edge
+// of the ellipse inscribed in its bounds. The width of the fade is a
+// fraction of the ellipse's radius.
+func featherMask(face *image.NRGBA, width float64) *image.NRGBA {
+	var (
+		masked = imaging.Clone(face)
+		b      = masked.Rect
+		rx     = float64(b.Dx()) / 2
+		ry     = float64(b.Dy()) / 2
+		inner  = math.Max(1-width, 0)
+	)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var (
+				dx = (float64(x) + 0.5 - rx) / rx
+				dy = (float64(y) + 0.5 - ry) / ry
+				d  = math.Hypot(dx, dy)
+				i  = masked.PixOffset(x, y)
+			)
+			switch {
+			case d >= 1:
+				masked.Pix[i+3] = 0
+			case d > inner:
+				// smoothstep from the inner edge of the fade to the ellipse
+				t := (1 - d) / (1 - inner)
+				t = t * t * (3 - 2*t)
+				masked.Pix[i+3] = uint8(float64(masked.Pix[i+3])*t + 0.5)
+			}
+		}
+	}
+	return masked
+}
+
+// seamlessClone solves the Poisson equation so the result has the gradients
+// of the face inside the face's opaque pixels and matches the canvas on their
+// boundary. The result is composited with the face's alpha so soft edges
+// stay soft.
+func seamlessClone(canvas *image.NRGBA, face *image.NRGBA, pt image.Point, iterations int, opacity float64) *image.NRGBA {
+	var (
+		r = face.Rect.Add(pt.Sub(face.Rect.Min)).Intersect(canvas.Rect)
+		w = r.Dx()
+		h = r.Dy()
+	)
+	if r.Empty() {
+		return canvas
+	}
+	var (
+		// offset from canvas coordinates to face coordinates
+		off    = face.Rect.Min.Sub(pt)
+		inside = make([]bool, w*h)
+		alpha  = make([]float64, w*h)
+	)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := face.Pix[face.PixOffset(r.Min.X+x+off.X, r.Min.Y+y+off.Y)+3]
+			alpha[y*w+x] = float64(a) / 255
+			inside[y*w+x] = a >= 128
+		}
+	}
+	var (
+		facePix = func(x, y, c int) float64 {
+			return float64(face.Pix[face.PixOffset(r.Min.X+x+off.X, r.Min.Y+y+off.Y)+c])
+		}
+		canvasPix = func(x, y, c int) float64 {
+			return float64(canvas.Pix[canvas.PixOffset(r.Min.X+x, r.Min.Y+y)+c])
+		}
+		neighbors = [4]image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+		result    = make([][]float64, 3)
+		shifts    [3]float64
+	)
+	for c := 0; c < 3; c++ {
+		var (
+			f        = make([]float64, w*h)
+			guide    = make([]float64, w*h)
+			count    = make([]float64, w*h)
+			boundary float64
+			nb       int
+		)
+		// the guidance is the sum of the face's gradients towards its
+		// opaque neighbors plus the fixed canvas values on the boundary.
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				i := y*w + x
+				if !inside[i] {
+					continue
+				}
+				for _, n := range neighbors {
+					nx, ny := x+n.X, y+n.Y
+					cx, cy := r.Min.X+nx, r.Min.Y+ny
+					if !image.Pt(cx, cy).In(canvas.Rect) {
+						continue
+					}
+					count[i]++
+					inRect := nx >= 0 && nx < w && ny >= 0 && ny < h
+					if inRect && inside[ny*w+nx] {
+						guide[i] += facePix(x, y, c) - facePix(nx, ny, c)
+					} else {
+						var v float64
+						if inRect {
+							v = canvasPix(nx, ny, c)
+						} else {
+							v = float64(canvas.Pix[canvas.PixOffset(cx, cy)+c])
+						}
+						guide[i] += v
+						boundary += v - facePix(x, y, c)
+						nb++
+					}
+				}
+			}
+		}
+		// start from the face shifted by the average difference
+		// along the boundary so fewer iterations are needed.
+		var shift float64
+		if nb > 0 {
+			shift = boundary / float64(nb)
+		}
+		shifts[c] = shift
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if inside[y*w+x] {
+					f[y*w+x] = facePix(x, y, c) + shift
+				}
+			}
+		}
+		// successive over-relaxation
+		const omega = 1.9
+		for iter := 0; iter < iterations; iter++ {
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					i := y*w + x
+					if !inside[i] || count[i] == 0 {
+						continue
+					}
+					sum := guide[i]
+					if x > 0 && inside[i-1] {
+						sum += f[i-1]
+					}
+					if x < w-1 && inside[i+1] {
+						sum += f[i+1]
+					}
+					if y > 0 && inside[i-w] {
+						sum += f[i-w]
+					}
+					if y < h-1 && inside[i+w] {
+						sum += f[i+w]
+					}
+					f[i] += omega * (sum/count[i] - f[i])
+				}
+			}
+		}
+		result[c] = f
+	}
+
+	out := image.NewNRGBA(canvas.Rect)
+	copy(out.Pix, canvas.Pix)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			a := alpha[i] * opacity
+			if a <= 0 {
+				continue
+			}
+			o := out.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for c := 0; c < 3; c++ {
+				// the translucent edge pixels aren't part of the solution,
+				// so they're only shifted to roughly match.
+				v := facePix(x, y, c) + shifts[c]
+				if inside[i] {
+					v = result[c][i]
+				}
+				v = math.Max(0, math.Min(255, v))
+				out.Pix[o+c] = uint8(float64(out.Pix[o+c])*(1-a) + v*a + 0.5)
+			}
+			out.Pix[o+3] = uint8(math.Min(255, float64(out.Pix[o+3])*(1-a)+255*a) + 0.5)
+		}
+	}
+	return out
+}
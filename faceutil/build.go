@@ -0,0 +1,189 @@
+package faceutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// BuildOptions configure a PackBuilder
+type BuildOptions struct {
+	// Name is the name of the face pack
+	Name string
+
+	// Margin is the padding added around the detected faces as a
+	// percentage of their size. It's the same as RenderOptions.Margin
+	// so the crops include the hair.
+	Margin float64
+
+	// Size is the width of the face images in pixels
+	Size int
+
+	// MinSize skips detected faces narrower than this many pixels
+	MinSize int
+
+	// MaxDistance is the most bits the hashes of two faces can differ
+	// by for them to be near-duplicates. Only the largest one is kept.
+	MaxDistance int
+
+	// Cutout removes the background around the faces
+	Cutout bool
+}
+
+// builtFace is a face cropped from a photo
+type builtFace struct {
+	source string
+	img    *image.NRGBA
+	pose   Pose
+	hash   uint64
+
+	// width is the width of the face in the photo
+	width int
+}
+
+// PackBuilder makes a face pack out of the faces in ordinary photos
+type PackBuilder struct {
+	opt      BuildOptions
+	detector Detector
+	faces    []builtFace
+}
+
+func NewPackBuilder(d Detector, opt BuildOptions) *PackBuilder {
+	return &PackBuilder{opt: opt, detector: d}
+}
+
+// Add crops the faces out of the photo. Faces which are near-duplicates
+// of ones already added replace them when they're larger and are dropped
+// otherwise. It returns the number of faces added and dropped.
+func (b *PackBuilder) Add(source string, img image.Image) (added, dropped int) {
+	canvas := canvasFromImage(img)
+	for _, face := range b.detector.Detect(canvas) {
+		if face.Rect.Dx() < b.opt.MinSize {
+			continue
+		}
+		f := builtFace{
+			source: source,
+			img:    b.cropFace(canvas, face),
+			pose:   face.Pose,
+			width:  face.Rect.Dx(),
+		}
+		f.hash = faceHash(f.img)
+		if i, ok := b.duplicate(f.hash); ok {
+			if f.width > b.faces[i].width {
+				b.faces[i] = f
+			}
+			dropped++
+			continue
+		}
+		b.faces = append(b.faces, f)
+		added++
+	}
+	return added, dropped
+}
+
+// Len returns the number of faces in the pack
+func (b *PackBuilder) Len() int {
+	return len(b.faces)
+}
+
+// cropFace crops the padded face, straightens it, removes the background,
+// and resizes it to the pack's width
+func (b *PackBuilder) cropFace(canvas *image.NRGBA, face Detection) *image.NRGBA {
+	var (
+		padded = addRectPadding(b.opt.Margin, face.Rect, canvas.Rect).Intersect(canvas.Rect)
+		crop   = imaging.Crop(canvas, padded)
+		inner  = face.Rect.Sub(padded.Min)
+	)
+	if face.Angle != 0 {
+		// rotate the crop back upright. The rotated crop is bigger, so
+		// it's cropped back to the padded size around the same center.
+		var (
+			rotated = imaging.Rotate(crop, -face.Angle, color.Transparent)
+			center  = rotatePoint(getRectCenter(inner), getRectCenter(crop.Rect), -face.Angle)
+		)
+		crop = imaging.Crop(rotated, getRectCenteredIn(crop.Rect, rotated.Rect))
+		inner = getRectCenteredAt(inner, center)
+	}
+	if b.opt.Cutout {
+		crop = cutout(crop, inner)
+	}
+	if b.opt.Size > 0 {
+		crop = imaging.Resize(crop, b.opt.Size, 0, imaging.Lanczos)
+	}
+	return crop
+}
+
+// duplicate returns the index of a face whose hash is within MaxDistance
+// bits of the hash
+func (b *PackBuilder) duplicate(hash uint64) (int, bool) {
+	for i, f := range b.faces {
+		if bits.OnesCount64(f.hash^hash) <= b.opt.MaxDistance {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// faceHash is a difference hash of the face. Each bit is whether a pixel
+// is brighter than its right neighbour in a 9x8 grayscale thumbnail. The
+// transparent background is flattened to gray first.
+func faceHash(img *image.NRGBA) uint64 {
+	var (
+		flat  = imaging.Overlay(imaging.New(img.Rect.Dx(), img.Rect.Dy(), color.Gray{128}), img, image.ZP, 1)
+		thumb = imaging.Resize(imaging.Grayscale(flat), 9, 8, imaging.Box)
+		hash  uint64
+	)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if thumb.Pix[thumb.PixOffset(x, y)] > thumb.Pix[thumb.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Write writes the face images and a manifest to the directory. The faces
+// are written in the order of their source photos.
+func (b *PackBuilder) Write(dir string) error {
+	if len(b.faces) == 0 {
+		return fmt.Errorf("no faces to write to %s", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "faces"), 0755); err != nil {
+		return err
+	}
+	faces := append([]builtFace(nil), b.faces...)
+	sort.SliceStable(faces, func(i, j int) bool {
+		return faces[i].source < faces[j].source
+	})
+	m := packManifest{Name: b.opt.Name}
+	if m.Name == "" {
+		m.Name = filepath.Base(dir)
+	}
+	for i, f := range faces {
+		name := path.Join("faces", fmt.Sprintf("face_%d.png", i))
+		if err := writePNG(filepath.Join(dir, filepath.FromSlash(name)), f.img); err != nil {
+			return err
+		}
+		mf := manifestFace{File: name}
+		if f.pose != PoseFrontal {
+			mf.Pose = f.pose.String()
+		}
+		m.Faces = append(m.Faces, mf)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, manifestName), append(data, '\n'), 0644)
+}
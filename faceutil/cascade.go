@@ -0,0 +1,259 @@
+package faceutil
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cascade is a Haar cascade classifier loaded from an OpenCV XML file.
+// Both the old (opencv-haar-classifier) and new (opencv-cascade-classifier)
+// formats are supported.
+type cascade struct {
+	width     int
+	height    int
+	features  []haarFeature
+	stages    []haarStage
+	hasTilted bool
+}
+
+type haarRect struct {
+	x, y, w, h int
+	weight     float64
+}
+
+type haarFeature struct {
+	rects  []haarRect
+	tilted bool
+}
+
+// haarNode is a split in a tree. Positive left/right values are indexes of
+// other nodes in the tree and non-positive values are negated leaf indexes.
+type haarNode struct {
+	feature   int
+	threshold float64
+	left      int
+	right     int
+}
+
+type haarTree struct {
+	nodes  []haarNode
+	leaves []float64
+}
+
+type haarStage struct {
+	trees     []haarTree
+	threshold float64
+}
+
+type xmlStorage struct {
+	Cascade xmlCascade `xml:",any"`
+}
+
+type xmlCascade struct {
+	TypeID string `xml:"type_id,attr"`
+
+	// opencv-haar-classifier
+	Size string `xml:"size"`
+
+	// opencv-cascade-classifier
+	StageType   string       `xml:"stageType"`
+	FeatureType string       `xml:"featureType"`
+	Width       int          `xml:"width"`
+	Height      int          `xml:"height"`
+	Features    []xmlFeature `xml:"features>_"`
+
+	Stages []xmlStage `xml:"stages>_"`
+}
+
+type xmlStage struct {
+	// opencv-haar-classifier
+	Trees          []xmlTree `xml:"trees>_"`
+	StageThreshold float64   `xml:"stage_threshold"`
+
+	// opencv-cascade-classifier
+	WeakClassifiers []xmlWeakClassifier `xml:"weakClassifiers>_"`
+	Threshold       float64             `xml:"stageThreshold"`
+}
+
+type xmlTree struct {
+	Nodes []xmlNode `xml:"_"`
+}
+
+type xmlNode struct {
+	Feature   xmlFeature `xml:"feature"`
+	Threshold float64    `xml:"threshold"`
+	LeftVal   *float64   `xml:"left_val"`
+	LeftNode  *int       `xml:"left_node"`
+	RightVal  *float64   `xml:"right_val"`
+	RightNode *int       `xml:"right_node"`
+}
+
+type xmlWeakClassifier struct {
+	InternalNodes string `xml:"internalNodes"`
+	LeafValues    string `xml:"leafValues"`
+}
+
+type xmlFeature struct {
+	Rects  []string `xml:"rects>_"`
+	Tilted int      `xml:"tilted"`
+}
+
+func loadCascade(filename string) (*cascade, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var s xmlStorage
+	if err := xml.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	var c *cascade
+	switch s.Cascade.TypeID {
+	case "opencv-haar-classifier":
+		c, err = parseHaarClassifier(&s.Cascade)
+	case "opencv-cascade-classifier":
+		c, err = parseCascadeClassifier(&s.Cascade)
+	default:
+		err = fmt.Errorf("unsupported cascade type: %q", s.Cascade.TypeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	for _, f := range c.features {
+		if f.tilted {
+			c.hasTilted = true
+		}
+	}
+	return c, nil
+}
+
+func parseHaarClassifier(x *xmlCascade) (*cascade, error) {
+	var c cascade
+	if _, err := fmt.Sscan(x.Size, &c.width, &c.height); err != nil {
+		return nil, fmt.Errorf("invalid size: %s", err)
+	}
+	for _, xs := range x.Stages {
+		stage := haarStage{threshold: xs.StageThreshold}
+		for _, xt := range xs.Trees {
+			var tree haarTree
+			// leaf values and node indexes are mapped onto the same
+			// representation used by the new format.
+			child := func(val *float64, node *int) (int, error) {
+				switch {
+				case node != nil:
+					return *node, nil
+				case val != nil:
+					tree.leaves = append(tree.leaves, *val)
+					return -(len(tree.leaves) - 1), nil
+				default:
+					return 0, errors.New("node has no children")
+				}
+			}
+			for _, xn := range xt.Nodes {
+				feature, err := parseFeature(xn.Feature)
+				if err != nil {
+					return nil, err
+				}
+				c.features = append(c.features, feature)
+				left, err := child(xn.LeftVal, xn.LeftNode)
+				if err != nil {
+					return nil, err
+				}
+				right, err := child(xn.RightVal, xn.RightNode)
+				if err != nil {
+					return nil, err
+				}
+				tree.nodes = append(tree.nodes, haarNode{
+					feature:   len(c.features) - 1,
+					threshold: xn.Threshold,
+					left:      left,
+					right:     right,
+				})
+			}
+			stage.trees = append(stage.trees, tree)
+		}
+		c.stages = append(c.stages, stage)
+	}
+	return &c, nil
+}
+
+func parseCascadeClassifier(x *xmlCascade) (*cascade, error) {
+	if x.StageType != "BOOST" || x.FeatureType != "HAAR" {
+		return nil, fmt.Errorf("unsupported cascade: %s/%s", x.StageType, x.FeatureType)
+	}
+	c := cascade{
+		width:  x.Width,
+		height: x.Height,
+	}
+	for _, xf := range x.Features {
+		feature, err := parseFeature(xf)
+		if err != nil {
+			return nil, err
+		}
+		c.features = append(c.features, feature)
+	}
+	for _, xs := range x.Stages {
+		stage := haarStage{threshold: xs.Threshold}
+		for _, xw := range xs.WeakClassifiers {
+			var tree haarTree
+			nodes, err := parseNumbers(xw.InternalNodes)
+			if err != nil {
+				return nil, err
+			}
+			if len(nodes)%4 != 0 {
+				return nil, errors.New("invalid internal nodes")
+			}
+			for i := 0; i < len(nodes); i += 4 {
+				node := haarNode{
+					left:      int(nodes[i]),
+					right:     int(nodes[i+1]),
+					feature:   int(nodes[i+2]),
+					threshold: nodes[i+3],
+				}
+				if node.feature < 0 || node.feature >= len(c.features) {
+					return nil, fmt.Errorf("invalid feature index: %d", node.feature)
+				}
+				tree.nodes = append(tree.nodes, node)
+			}
+			tree.leaves, err = parseNumbers(xw.LeafValues)
+			if err != nil {
+				return nil, err
+			}
+			stage.trees = append(stage.trees, tree)
+		}
+		c.stages = append(c.stages, stage)
+	}
+	return &c, nil
+}
+
+func parseFeature(xf xmlFeature) (haarFeature, error) {
+	feature := haarFeature{tilted: xf.Tilted != 0}
+	for _, s := range xf.Rects {
+		var r haarRect
+		if _, err := fmt.Sscan(s, &r.x, &r.y, &r.w, &r.h, &r.weight); err != nil {
+			return feature, fmt.Errorf("invalid rect %q: %s", s, err)
+		}
+		feature.rects = append(feature.rects, r)
+	}
+	if len(feature.rects) == 0 {
+		return feature, errors.New("feature has no rects")
+	}
+	return feature, nil
+}
+
+func parseNumbers(s string) ([]float64, error) {
+	var nn []float64
+	for _, f := range strings.Fields(s) {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		nn = append(nn, n)
+	}
+	return nn, nil
+}
@@ -0,0 +1,520 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// CascadeDetector is a pure Go implementation of the Haar cascade
+// classifier. It reads the same XML files as the OpenCV detector.
+type CascadeDetector struct {
+	cascade *cascade
+	opt     CascadeOptions
+}
+
+func NewCascadeDetector(cascadeFile string, opt CascadeOptions) (*CascadeDetector, error) {
+	if opt.ScaleFactor <= 1 {
+		return nil, fmt.Errorf("invalid scale factor: %g", opt.ScaleFactor)
+	}
+	c, err := loadCascade(cascadeFile)
+	if err != nil {
+		return nil, err
+	}
+	return &CascadeDetector{
+		cascade: c,
+		opt:     opt,
+	}, nil
+}
+
+func (d *CascadeDetector) Detect(i image.Image) []Detection {
+	return d.detectResized(i, 1)
+}
+
+func (d *CascadeDetector) detectResized(i image.Image, scale float64) []Detection {
+	var (
+		ii         = newIntegralImage(toGray(i), d.cascade.hasTilted)
+		candidates = d.cascade.scan(ii, d.opt.scaled(scale))
+		offset     = i.Bounds().Min
+	)
+	var output []Detection
+	for _, det := range groupRects(candidates, d.opt.MinNeighbor, 0.2) {
+		det.Rect = det.Rect.Add(offset)
+		output = append(output, det)
+	}
+	return output
+}
+
+// integralImage holds the summed area tables required to evaluate haar
+// features in constant time.
+type integralImage struct {
+	width  int
+	height int
+
+	sum   []int64
+	sqsum []int64
+
+	// the tilted table is padded horizontally because the rotated
+	// triangles it sums over extend past the image edges.
+	tilted    []int64
+	tiltedPad int
+}
+
+func newIntegralImage(gray *image.Gray, withTilted bool) *integralImage {
+	var (
+		w      = gray.Rect.Dx()
+		h      = gray.Rect.Dy()
+		stride = w + 1
+		ii     = &integralImage{
+			width:  w,
+			height: h,
+			sum:    make([]int64, stride*(h+1)),
+			sqsum:  make([]int64, stride*(h+1)),
+		}
+	)
+	for y := 0; y < h; y++ {
+		var rowSum, rowSqSum int64
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+w]
+		for x, p := range row {
+			v := int64(p)
+			rowSum += v
+			rowSqSum += v * v
+			ii.sum[(y+1)*stride+x+1] = ii.sum[y*stride+x+1] + rowSum
+			ii.sqsum[(y+1)*stride+x+1] = ii.sqsum[y*stride+x+1] + rowSqSum
+		}
+	}
+	if withTilted {
+		ii.computeTilted(gray)
+	}
+	return ii
+}
+
+// computeTilted builds the rotated summed area table where
+// tilted(X, Y) is the sum of pixels (x, y) with y < Y and |x-X+1| <= Y-y-1
+func (ii *integralImage) computeTilted(gray *image.Gray) {
+	var (
+		w      = ii.width
+		h      = ii.height
+		pad    = h + 2
+		stride = w + 1 + 2*pad
+		t      = make([]int64, stride*(h+1))
+		pixel  = func(x, y int) int64 {
+			if x < 0 || x >= w || y < 0 || y >= h {
+				return 0
+			}
+			return int64(gray.Pix[y*gray.Stride+x])
+		}
+		at = func(x, y int) int64 {
+			if y < 0 || x+pad < 0 || x+pad >= stride {
+				return 0
+			}
+			return t[y*stride+x+pad]
+		}
+	)
+	for y := 1; y <= h; y++ {
+		for x := -pad; x < stride-pad; x++ {
+			t[y*stride+x+pad] = at(x-1, y-1) + at(x+1, y-1) - at(x, y-2) +
+				pixel(x-1, y-1) + pixel(x-1, y-2)
+		}
+	}
+	ii.tilted = t
+	ii.tiltedPad = pad
+}
+
+func (ii *integralImage) rectSum(table []int64, x, y, w, h int) int64 {
+	stride := ii.width + 1
+	return table[y*stride+x] - table[y*stride+x+w] -
+		table[(y+h)*stride+x] + table[(y+h)*stride+x+w]
+}
+
+func (ii *integralImage) tiltedStride() int {
+	return ii.width + 1 + 2*ii.tiltedPad
+}
+
+// scaledFeature is a feature resized to the current window size. The
+// rectangles are stored as offsets into the integral image relative to
+// the window origin.
+type scaledFeature struct {
+	n       int
+	tilted  bool
+	offsets [3][4]int
+	weights [3]float64
+}
+
+func (f *scaledFeature) eval(ii *integralImage, sumBase, tiltedBase int) float64 {
+	table, base := ii.sum, sumBase
+	if f.tilted {
+		table, base = ii.tilted, tiltedBase
+	}
+	var sum float64
+	for i := 0; i < f.n; i++ {
+		o := &f.offsets[i]
+		sum += float64(table[base+o[0]]-table[base+o[1]]-table[base+o[2]]+table[base+o[3]]) * f.weights[i]
+	}
+	return sum
+}
+
+// scaleFeatures resizes the features to the window scale. Rounding can make
+// the scaled rectangles overhang the window, so the size of the area they
+// cover is also returned.
+func (c *cascade) scaleFeatures(ii *integralImage, factor float64, weightScale float64) ([]scaledFeature, image.Point) {
+	var (
+		features = make([]scaledFeature, len(c.features))
+		extent   image.Point
+		stride   = ii.width + 1
+		tstride  = ii.tiltedStride()
+	)
+	for i, f := range c.features {
+		sf := &features[i]
+		sf.tilted = f.tilted
+		sf.n = minInt(len(f.rects), len(sf.offsets))
+		correction := weightScale
+		if f.tilted {
+			correction *= 0.5
+		}
+		var sum0, area0 float64
+		for k := 0; k < sf.n; k++ {
+			var (
+				r = f.rects[k]
+				x = round(float64(r.x) * factor)
+				y = round(float64(r.y) * factor)
+				w = round(float64(r.w) * factor)
+				h = round(float64(r.h) * factor)
+			)
+			if f.tilted {
+				sf.offsets[k] = [4]int{
+					y*tstride + x,
+					(y+h)*tstride + x - h,
+					(y+w)*tstride + x + w,
+					(y+w+h)*tstride + x + w - h,
+				}
+				extent.X = maxInt(extent.X, x+w)
+				extent.Y = maxInt(extent.Y, y+w+h)
+			} else {
+				sf.offsets[k] = [4]int{
+					y*stride + x,
+					y*stride + x + w,
+					(y+h)*stride + x,
+					(y+h)*stride + x + w,
+				}
+				extent.X = maxInt(extent.X, x+w)
+				extent.Y = maxInt(extent.Y, y+h)
+			}
+			sf.weights[k] = r.weight * correction
+			area := float64(w * h)
+			if k == 0 {
+				area0 = area
+			} else {
+				sum0 += sf.weights[k] * area
+			}
+		}
+		// correct the weight of the first rectangle so that rounding errors
+		// in the scaled rectangles don't bias the feature.
+		if area0 > 0 {
+			sf.weights[0] = -sum0 / area0
+		}
+	}
+	return features, extent
+}
+
+// stageThresholdBias matches the tolerance used by OpenCV
+const stageThresholdBias = 0.0001
+
+// candidate is a window which passed all the stages. The weight is the
+// sum of the last stage.
+type candidate struct {
+	rect   image.Rectangle
+	weight float64
+}
+
+// scan slides the cascade window over the image at every scale allowed by
+// the options and returns the windows which passed all the stages. Each
+// scale is evaluated in its own goroutine.
+func (c *cascade) scan(ii *integralImage, opt CascadeOptions) []candidate {
+	var factors []float64
+	for factor := 1.0; ; factor *= opt.ScaleFactor {
+		var (
+			winW = round(float64(c.width) * factor)
+			winH = round(float64(c.height) * factor)
+		)
+		if winW > ii.width || winH > ii.height {
+			break
+		}
+		if opt.MaxSize > 0 && winW > opt.MaxSize {
+			break
+		}
+		if winW < opt.MinSize {
+			continue
+		}
+		factors = append(factors, factor)
+	}
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, runtime.NumCPU())
+		results = make([][]candidate, len(factors))
+	)
+	for i, factor := range factors {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, factor float64) {
+			defer wg.Done()
+			results[i] = c.scanScale(ii, factor)
+			<-sem
+		}(i, factor)
+	}
+	wg.Wait()
+	var candidates []candidate
+	for _, cc := range results {
+		candidates = append(candidates, cc...)
+	}
+	return candidates
+}
+
+func (c *cascade) scanScale(ii *integralImage, factor float64) []candidate {
+	var (
+		winW = round(float64(c.width) * factor)
+		winH = round(float64(c.height) * factor)
+
+		// the variance is normalized over the window minus a 1px border
+		normX            = round(factor)
+		normY            = round(factor)
+		normW            = round(float64(c.width-2) * factor)
+		normH            = round(float64(c.height-2) * factor)
+		weightScale      = 1 / float64(normW*normH)
+		features, extent = c.scaleFeatures(ii, factor, weightScale)
+		step             = math.Max(2, factor)
+		maxX             = ii.width - maxInt(winW, extent.X)
+		maxY             = ii.height - maxInt(winH, extent.Y)
+
+		candidates []candidate
+	)
+	for fy := 0.0; round(fy) <= maxY; fy += step {
+		for fx := 0.0; round(fx) <= maxX; fx += step {
+			x, y := round(fx), round(fy)
+			var (
+				mean = float64(ii.rectSum(ii.sum, x+normX, y+normY, normW, normH)) * weightScale
+				nf   = float64(ii.rectSum(ii.sqsum, x+normX, y+normY, normW, normH))*weightScale - mean*mean
+			)
+			if nf > 0 {
+				nf = math.Sqrt(nf)
+			} else {
+				nf = 1
+			}
+			var (
+				sumBase    = y*(ii.width+1) + x
+				tiltedBase = y*ii.tiltedStride() + x + ii.tiltedPad
+			)
+			if weight, ok := c.evalStages(ii, features, sumBase, tiltedBase, nf); ok {
+				candidates = append(candidates, candidate{
+					rect:   image.Rect(x, y, x+winW, y+winH),
+					weight: weight,
+				})
+			}
+		}
+	}
+	return candidates
+}
+
+// evalStages returns the sum of the last stage and whether
+// the window passed all the stages.
+func (c *cascade) evalStages(ii *integralImage, features []scaledFeature, sumBase, tiltedBase int, nf float64) (float64, bool) {
+	var sum float64
+	for i := range c.stages {
+		stage := &c.stages[i]
+		sum = 0
+		for j := range stage.trees {
+			var (
+				tree = &stage.trees[j]
+				idx  = 0
+			)
+			for {
+				node := &tree.nodes[idx]
+				if features[node.feature].eval(ii, sumBase, tiltedBase) < node.threshold*nf {
+					idx = node.left
+				} else {
+					idx = node.right
+				}
+				if idx <= 0 {
+					break
+				}
+			}
+			sum += tree.leaves[-idx]
+		}
+		if sum < stage.threshold-stageThresholdBias {
+			return sum, false
+		}
+	}
+	return sum, true
+}
+
+// groupRects clusters similar rectangles and averages each cluster.
+// Clusters with minNeighbor or fewer members are discarded and so are
+// clusters mostly contained in a stronger one. The detection score is
+// the number of rectangles in the cluster and the weight is the highest
+// weight in the cluster.
+func groupRects(candidates []candidate, minNeighbor int, eps float64) []Detection {
+	if minNeighbor <= 0 {
+		var output []Detection
+		for _, c := range candidates {
+			output = append(output, Detection{Rect: c.rect, Score: 1, Weight: c.weight})
+		}
+		return output
+	}
+
+	rects := make([]image.Rectangle, len(candidates))
+	for i, c := range candidates {
+		rects[i] = c.rect
+	}
+
+	// partition using union-find
+	parent := make([]int, len(rects))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			if similarRects(rects[i], rects[j], eps) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	// average the clusters
+	type cluster struct {
+		x, y, w, h int
+		n          int
+		weight     float64
+	}
+	var (
+		clusters []*cluster
+		byRoot   = map[int]*cluster{}
+	)
+	for i, r := range rects {
+		root := find(i)
+		c, ok := byRoot[root]
+		if !ok {
+			c = &cluster{}
+			byRoot[root] = c
+			clusters = append(clusters, c)
+		}
+		if c.n == 0 || candidates[i].weight > c.weight {
+			c.weight = candidates[i].weight
+		}
+		c.x += r.Min.X
+		c.y += r.Min.Y
+		c.w += r.Dx()
+		c.h += r.Dy()
+		c.n++
+	}
+	averaged := make([]image.Rectangle, len(clusters))
+	for i, c := range clusters {
+		n := float64(c.n)
+		x := round(float64(c.x) / n)
+		y := round(float64(c.y) / n)
+		averaged[i] = image.Rect(x, y,
+			x+round(float64(c.w)/n),
+			y+round(float64(c.h)/n),
+		)
+	}
+
+	// drop clusters inside stronger ones
+	var output []Detection
+	for i, r1 := range averaged {
+		n1 := clusters[i].n
+		if n1 <= minNeighbor {
+			continue
+		}
+		contained := false
+		for j, r2 := range averaged {
+			n2 := clusters[j].n
+			if i == j || n2 <= minNeighbor {
+				continue
+			}
+			var (
+				dx = round(float64(r2.Dx()) * eps)
+				dy = round(float64(r2.Dy()) * eps)
+			)
+			if r1.Min.X >= r2.Min.X-dx &&
+				r1.Min.Y >= r2.Min.Y-dy &&
+				r1.Max.X <= r2.Max.X+dx &&
+				r1.Max.Y <= r2.Max.Y+dy &&
+				(n2 > maxInt(3, n1) || n1 < 3) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			output = append(output, Detection{
+				Rect:   r1,
+				Score:  float64(n1),
+				Weight: clusters[i].weight,
+			})
+		}
+	}
+	return output
+}
+
+func similarRects(r1, r2 image.Rectangle, eps float64) bool {
+	delta := eps * float64(minInt(r1.Dx(), r2.Dx())+minInt(r1.Dy(), r2.Dy())) * 0.5
+	return math.Abs(float64(r1.Min.X-r2.Min.X)) <= delta &&
+		math.Abs(float64(r1.Min.Y-r2.Min.Y)) <= delta &&
+		math.Abs(float64(r1.Max.X-r2.Max.X)) <= delta &&
+		math.Abs(float64(r1.Max.Y-r2.Max.Y)) <= delta
+}
+
+func toGray(i image.Image) *image.Gray {
+	switch i := i.(type) {
+	case *image.Gray:
+		if i.Rect.Min == (image.Point{}) {
+			return i
+		}
+	case *image.YCbCr:
+		// the luma plane is already the grayscale image
+		b := i.Rect
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			off := i.YOffset(b.Min.X, b.Min.Y+y)
+			copy(gray.Pix[y*gray.Stride:], i.Y[off:off+b.Dx()])
+		}
+		return gray
+	case *image.NRGBA:
+		// same conversion as color.GrayModel on the premultiplied colors
+		b := i.Rect
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			var (
+				src = i.Pix[y*i.Stride : y*i.Stride+b.Dx()*4]
+				dst = gray.Pix[y*gray.Stride : y*gray.Stride+b.Dx()]
+			)
+			for x := range dst {
+				var (
+					p = src[x*4 : x*4+4 : x*4+4]
+					a = uint32(p[3]) * 0x101
+					r = uint32(p[0]) * 0x101 * a / 0xffff
+					g = uint32(p[1]) * 0x101 * a / 0xffff
+					b = uint32(p[2]) * 0x101 * a / 0xffff
+				)
+				dst[x] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+			}
+		}
+		return gray
+	}
+	b := i.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Rect, i, b.Min, draw.Src)
+	return gray
+}
+
+func round(f float64) int {
+	return int(math.Floor(f + 0.5))
+}
@@ -0,0 +1,181 @@
+package faceutil
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// randomGray returns a small image filled with random pixels
+func randomGray(w, h int) *image.Gray {
+	var (
+		rnd  = rand.New(rand.NewSource(1))
+		gray = image.NewGray(image.Rect(0, 0, w, h))
+	)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(rnd.Intn(256))
+	}
+	return gray
+}
+
+func TestIntegralImageRectSum(t *testing.T) {
+	var (
+		w, h = 7, 5
+		gray = randomGray(w, h)
+		ii   = newIntegralImage(gray, false)
+	)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for rh := 0; y+rh <= h; rh++ {
+				for rw := 0; x+rw <= w; rw++ {
+					var sum, sqsum int64
+					for py := y; py < y+rh; py++ {
+						for px := x; px < x+rw; px++ {
+							v := int64(gray.Pix[py*gray.Stride+px])
+							sum += v
+							sqsum += v * v
+						}
+					}
+					if got := ii.rectSum(ii.sum, x, y, rw, rh); got != sum {
+						t.Fatalf("sum of %dx%d at %d,%d: got %d, want %d", rw, rh, x, y, got, sum)
+					}
+					if got := ii.rectSum(ii.sqsum, x, y, rw, rh); got != sqsum {
+						t.Fatalf("squared sum of %dx%d at %d,%d: got %d, want %d", rw, rh, x, y, got, sqsum)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestIntegralImageTilted(t *testing.T) {
+	var (
+		w, h   = 7, 5
+		gray   = randomGray(w, h)
+		ii     = newIntegralImage(gray, true)
+		stride = ii.tiltedStride()
+	)
+	// tilted(X, Y) is the sum of the pixels (x, y) with y < Y and
+	// |x-X+1| <= Y-y-1, which is the triangle above the point
+	for Y := 0; Y <= h; Y++ {
+		for X := -ii.tiltedPad; X < stride-ii.tiltedPad; X++ {
+			var want int64
+			for y := 0; y < Y; y++ {
+				for x := 0; x < w; x++ {
+					if absInt(x-X+1) <= Y-y-1 {
+						want += int64(gray.Pix[y*gray.Stride+x])
+					}
+				}
+			}
+			if got := ii.tilted[Y*stride+X+ii.tiltedPad]; got != want {
+				t.Fatalf("tilted(%d, %d): got %d, want %d", X, Y, got, want)
+			}
+		}
+	}
+}
+
+func TestGroupRects(t *testing.T) {
+	var (
+		// five windows around the same face
+		face = []candidate{
+			{rect: image.Rect(10, 10, 50, 50), weight: 1},
+			{rect: image.Rect(11, 10, 51, 50), weight: 3},
+			{rect: image.Rect(10, 11, 50, 51), weight: 2},
+			{rect: image.Rect(9, 10, 49, 50), weight: 1},
+			{rect: image.Rect(10, 9, 50, 49), weight: 1},
+		}
+		// two windows around a weaker face somewhere else
+		weak = []candidate{
+			{rect: image.Rect(100, 100, 120, 120), weight: 1},
+			{rect: image.Rect(101, 100, 121, 120), weight: 1},
+		}
+		// two small windows inside the first face
+		inner = []candidate{
+			{rect: image.Rect(20, 20, 36, 36), weight: 5},
+			{rect: image.Rect(21, 20, 37, 36), weight: 5},
+		}
+		candidates []candidate
+	)
+	candidates = append(candidates, face...)
+	candidates = append(candidates, weak...)
+	candidates = append(candidates, inner...)
+
+	tests := []struct {
+		minNeighbor int
+		want        []Detection
+	}{
+		{
+			minNeighbor: 1,
+			want: []Detection{
+				{Rect: image.Rect(10, 10, 50, 50), Score: 5, Weight: 3},
+				{Rect: image.Rect(101, 100, 121, 120), Score: 2, Weight: 1},
+			},
+		},
+		{
+			minNeighbor: 2,
+			want: []Detection{
+				{Rect: image.Rect(10, 10, 50, 50), Score: 5, Weight: 3},
+			},
+		},
+		{
+			minNeighbor: 5,
+			want:        nil,
+		},
+	}
+	for _, tt := range tests {
+		got := groupRects(candidates, tt.minNeighbor, 0.2)
+		if len(got) != len(tt.want) {
+			t.Errorf("minNeighbor %d: got %v, want %v", tt.minNeighbor, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("minNeighbor %d: got %v, want %v", tt.minNeighbor, got[i], tt.want[i])
+			}
+		}
+	}
+
+	// every window is a detection when grouping is disabled
+	if got := groupRects(candidates, 0, 0.2); len(got) != len(candidates) {
+		t.Errorf("minNeighbor 0: got %d detections, want %d", len(got), len(candidates))
+	}
+}
+
+func TestCascadeDetector(t *testing.T) {
+	d, err := NewCascadeDetector("../haarcascade_frontalface_alt.xml", CascadeOptions{
+		MinNeighbor: 9,
+		ScaleFactor: 1.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file  string
+		faces []image.Rectangle
+	}{
+		{"testdata/golden/lena.jpg", []image.Rectangle{image.Rect(217, 201, 390, 374)}},
+		{"testdata/golden/baboon.jpg", nil},
+	}
+	for _, tt := range tests {
+		img, err := OpenImage(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := d.Detect(img)
+		if len(got) != len(tt.faces) {
+			t.Errorf("%s: found %d face(s), want %d", tt.file, len(got), len(tt.faces))
+			continue
+		}
+		for i, want := range tt.faces {
+			// the windows are averaged, so the rect can be off by a bit
+			var (
+				r   = got[i].Rect
+				tol = want.Dx() / 20
+			)
+			if absInt(r.Min.X-want.Min.X) > tol || absInt(r.Min.Y-want.Min.Y) > tol ||
+				absInt(r.Max.X-want.Max.X) > tol || absInt(r.Max.Y-want.Max.Y) > tol {
+				t.Errorf("%s: got %v, want %v within %dpx", tt.file, r, want, tol)
+			}
+		}
+	}
+}
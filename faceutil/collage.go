@@ -0,0 +1,95 @@
+package faceutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// CollagePhoto is a rendered photo and the faces it was rendered with
+type CollagePhoto struct {
+	Image image.Image
+	Faces []Detection
+}
+
+// CollageOptions configure the layout of collages
+type CollageOptions struct {
+	// Size is the width and height of the collage
+	Size int
+
+	// Gap is the space between the photos in pixels
+	Gap int
+
+	Background color.Color
+}
+
+// Collage lays the photos out in a square grid. Each photo is cropped around
+// its faces to fill its cell. When the last row isn't full, its cells are
+// widened to fill the row.
+func (r *Renderer) Collage(photos []CollagePhoto, opt CollageOptions) *image.NRGBA {
+	background := opt.Background
+	if background == nil {
+		background = color.White
+	}
+	canvas := imaging.New(opt.Size, opt.Size, background)
+	if len(photos) == 0 {
+		return canvas
+	}
+	var (
+		cols = int(math.Ceil(math.Sqrt(float64(len(photos)))))
+		rows = (len(photos) + cols - 1) / cols
+		gap  = opt.Gap
+	)
+	for row := 0; row < rows; row++ {
+		var (
+			first = row * cols
+			last  = minInt(first+cols, len(photos))
+			n     = last - first
+			y0    = gap + row*(opt.Size-gap)/rows
+			y1    = (row + 1) * (opt.Size - gap) / rows
+		)
+		for i := first; i < last; i++ {
+			var (
+				col  = i - first
+				x0   = gap + col*(opt.Size-gap)/n
+				x1   = (col + 1) * (opt.Size - gap) / n
+				cell = image.Rect(x0, y0, x1, y1)
+			)
+			if cell.Empty() {
+				continue
+			}
+			photo := r.fillCell(photos[i], cell.Dx(), cell.Dy())
+			draw.Draw(canvas, cell, photo, image.ZP, draw.Src)
+		}
+	}
+	return canvas
+}
+
+// fillCell crops the photo around its faces and resizes it to w x h. When
+// the faces don't all fit, the crop is centered on the largest one.
+func (r *Renderer) fillCell(p CollagePhoto, w, h int) *image.NRGBA {
+	var (
+		b      = p.Image.Bounds()
+		target = float64(w) / float64(h)
+	)
+	crop, ok := r.cropAround(b, p.Faces, target)
+	if !ok {
+		crop = cropCentered(b, getRectCenter(largestFace(p.Faces)), target)
+	}
+	return imaging.Resize(imaging.Crop(p.Image, crop), w, h, imaging.Lanczos)
+}
+
+// largestFace returns the rect of the widest face. The rect is empty
+// when there aren't any faces.
+func largestFace(faces []Detection) image.Rectangle {
+	var largest image.Rectangle
+	for _, face := range faces {
+		if face.Rect.Dx() > largest.Dx() {
+			largest = face.Rect
+		}
+	}
+	return largest
+}
@@ -0,0 +1,200 @@
+package faceutil
+
+import (
+	"image"
+	"math"
+)
+
+// labStats are the per channel mean and standard deviation of
+// some pixels in the CIELAB color space.
+type labStats struct {
+	mean [3]float64
+	std  [3]float64
+	n    int
+}
+
+// TransferColor adjusts the lighting and skin tone of the face image to
+// match the region of the canvas it's being drawn over. The mean and
+// variance of each channel are transferred in the CIELAB color space.
+// The strength goes from 0, which leaves the face alone, to 1.
+// The face image is modified in place.
+func TransferColor(face *image.NRGBA, canvas *image.NRGBA, region image.Rectangle, strength float64) {
+	if strength <= 0 {
+		return
+	}
+	strength = math.Min(strength, 1)
+	var (
+		src = faceStats(face)
+		dst = regionStats(canvas, region)
+	)
+	if src.n == 0 || dst.n == 0 {
+		return
+	}
+	var scale [3]float64
+	for c := range scale {
+		scale[c] = 1
+		if src.std[c] > 0 {
+			// limit the contrast change so a flat region
+			// doesn't wash the face out completely.
+			scale[c] = math.Max(0.5, math.Min(dst.std[c]/src.std[c], 2))
+		}
+	}
+	b := face.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := face.PixOffset(x, y)
+			p := face.Pix[i : i+4 : i+4]
+			if p[3] == 0 {
+				continue
+			}
+			lab := rgbToLab(p[0], p[1], p[2])
+			for c := range lab {
+				moved := (lab[c]-src.mean[c])*scale[c] + dst.mean[c]
+				lab[c] += (moved - lab[c]) * strength
+			}
+			p[0], p[1], p[2] = labToRGB(lab)
+		}
+	}
+}
+
+// faceStats measures the mostly opaque pixels of the face image
+func faceStats(face *image.NRGBA) labStats {
+	var acc labAccumulator
+	b := face.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := face.PixOffset(x, y)
+			if face.Pix[i+3] < 128 {
+				continue
+			}
+			acc.add(rgbToLab(face.Pix[i], face.Pix[i+1], face.Pix[i+2]))
+		}
+	}
+	return acc.stats()
+}
+
+// regionStats measures the middle of the region so that the background
+// around the detected face isn't included.
+func regionStats(canvas *image.NRGBA, region image.Rectangle) labStats {
+	var (
+		acc labAccumulator
+		dx  = region.Dx() / 5
+		dy  = region.Dy() / 5
+	)
+	region = image.Rect(
+		region.Min.X+dx, region.Min.Y+dy,
+		region.Max.X-dx, region.Max.Y-dy,
+	).Intersect(canvas.Rect)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			i := canvas.PixOffset(x, y)
+			acc.add(rgbToLab(canvas.Pix[i], canvas.Pix[i+1], canvas.Pix[i+2]))
+		}
+	}
+	return acc.stats()
+}
+
+type labAccumulator struct {
+	sum   [3]float64
+	sqsum [3]float64
+	n     int
+}
+
+func (a *labAccumulator) add(lab [3]float64) {
+	for c, v := range lab {
+		a.sum[c] += v
+		a.sqsum[c] += v * v
+	}
+	a.n++
+}
+
+func (a *labAccumulator) stats() labStats {
+	s := labStats{n: a.n}
+	if a.n == 0 {
+		return s
+	}
+	n := float64(a.n)
+	for c := range s.mean {
+		s.mean[c] = a.sum[c] / n
+		s.std[c] = math.Sqrt(math.Max(a.sqsum[c]/n-s.mean[c]*s.mean[c], 0))
+	}
+	return s
+}
+
+// srgbToLinear maps 8-bit sRGB values to linear light
+var srgbToLinear = func() [256]float64 {
+	var table [256]float64
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			table[i] = v / 12.92
+		} else {
+			table[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+func linearToSRGB(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Max(0, math.Min(255, math.Floor(v*255+0.5))))
+}
+
+// D65 reference white
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+func rgbToLab(r, g, b uint8) [3]float64 {
+	var (
+		lr = srgbToLinear[r]
+		lg = srgbToLinear[g]
+		lb = srgbToLinear[b]
+
+		x = (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / whiteX
+		y = (0.2126729*lr + 0.7151522*lg + 0.0721750*lb) / whiteY
+		z = (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / whiteZ
+
+		fx = labF(x)
+		fy = labF(y)
+		fz = labF(z)
+	)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func labToRGB(lab [3]float64) (uint8, uint8, uint8) {
+	var (
+		fy = (lab[0] + 16) / 116
+		fx = fy + lab[1]/500
+		fz = fy - lab[2]/200
+
+		x = labFInv(fx) * whiteX
+		y = labFInv(fy) * whiteY
+		z = labFInv(fz) * whiteZ
+	)
+	return linearToSRGB(3.2404542*x - 1.5371385*y - 0.4985314*z),
+		linearToSRGB(-0.9692660*x + 1.8760108*y + 0.0415560*z),
+		linearToSRGB(0.0556434*x - 0.2040259*y + 1.0572252*z)
+}
+
+const labEpsilon = 216.0 / 24389
+
+func labF(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t3 := t * t * t; t3 > labEpsilon {
+		return t3
+	}
+	return (116*t - 16) * 27 / 24389
+}
@@ -0,0 +1,185 @@
+//go:build cgo
+// +build cgo
+
+package faceutil
+
+import (
+	"image"
+	"sort"
+
+	"github.com/disintegration/imaging"
+	"github.com/lazywei/go-opencv/opencv"
+)
+
+// cutout removes the background around the face using OpenCV. Pixels which
+// differ from the color of the crop's border are thresholded, the detected
+// face is filled in, and everything outside the largest outline is made
+// transparent. The face rect is relative to the crop.
+func cutout(img *image.NRGBA, face image.Rectangle) *image.NRGBA {
+	var (
+		b    = img.Rect
+		w    = b.Dx()
+		h    = b.Dy()
+		diff = backgroundDiff(img)
+
+		src  = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 1)
+		bin  = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 1)
+		mask = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 1)
+	)
+	defer src.Release()
+	defer bin.Release()
+	defer mask.Release()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.Set2D(x, y, opencv.ScalarAll(float64(diff.Pix[diff.PixOffset(x, y)])))
+		}
+	}
+	opencv.Smooth(src, src, opencv.CV_GAUSSIAN, 5, 5, 0, 0)
+	opencv.Threshold(src, bin, float64(otsuThreshold(diff)), 255, opencv.CV_THRESH_BINARY)
+
+	// the face itself is never background
+	for y := face.Min.Y; y < face.Max.Y; y++ {
+		for x := face.Min.X; x < face.Max.X; x++ {
+			if image.Pt(x, y).In(b) && insideEllipse(face, x, y) {
+				bin.Set2D(x, y, opencv.ScalarAll(255))
+			}
+		}
+	}
+
+	// keep the largest outline, which is the one around the face
+	contours := bin.FindContours(opencv.CV_RETR_EXTERNAL, opencv.CV_CHAIN_APPROX_SIMPLE, opencv.Point{})
+	if contours == nil {
+		return featherMask(img, 0.2)
+	}
+	defer contours.Release()
+	var (
+		largest     *opencv.Seq
+		largestArea float64
+	)
+	for c := contours; c != nil; c = c.HNext() {
+		if area := opencv.ContourArea(c, opencv.WholeSeq(), 0); area > largestArea {
+			largest, largestArea = c, area
+		}
+	}
+	if largest == nil {
+		return featherMask(img, 0.2)
+	}
+	mask.Zero()
+	white := opencv.ScalarAll(255)
+	opencv.DrawContours(mask, largest, white, white, 0, -1, 8, opencv.Point{})
+
+	// soften the edge
+	k := maxInt(w/40, 1)*2 + 1
+	opencv.Smooth(mask, mask, opencv.CV_GAUSSIAN, k, k, 0, 0)
+
+	out := imaging.Clone(img)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var (
+				i = out.PixOffset(x, y)
+				a = mask.Get2D(x, y).Val()[0]
+			)
+			out.Pix[i+3] = uint8(float64(out.Pix[i+3]) * a / 255)
+		}
+	}
+	return out
+}
+
+// backgroundDiff returns how much each pixel differs from the median color
+// of the opaque pixels on the image's border. Transparent pixels don't
+// differ.
+func backgroundDiff(img *image.NRGBA) *image.Gray {
+	var (
+		b       = img.Rect
+		border  [3][]int
+		diff    = image.NewGray(b)
+		addEdge = func(x, y int) {
+			i := img.PixOffset(x, y)
+			if img.Pix[i+3] < 128 {
+				return
+			}
+			for c := 0; c < 3; c++ {
+				border[c] = append(border[c], int(img.Pix[i+c]))
+			}
+		}
+	)
+	for x := b.Min.X; x < b.Max.X; x++ {
+		addEdge(x, b.Min.Y)
+		addEdge(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		addEdge(b.Min.X, y)
+		addEdge(b.Max.X-1, y)
+	}
+	var median [3]int
+	for c := range border {
+		if len(border[c]) > 0 {
+			sort.Ints(border[c])
+			median[c] = border[c][len(border[c])/2]
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			if img.Pix[i+3] < 128 {
+				continue
+			}
+			var d int
+			for c := 0; c < 3; c++ {
+				d = maxInt(d, absInt(int(img.Pix[i+c])-median[c]))
+			}
+			diff.Pix[diff.PixOffset(x, y)] = uint8(d)
+		}
+	}
+	return diff
+}
+
+// otsuThreshold returns the threshold which best splits the image into
+// dark and light pixels
+func otsuThreshold(img *image.Gray) uint8 {
+	var (
+		hist  [256]int
+		total = len(img.Pix)
+		sum   float64
+	)
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+	for v, n := range hist {
+		sum += float64(v * n)
+	}
+	var (
+		best      uint8
+		bestVar   float64
+		darkSum   float64
+		darkCount int
+	)
+	for t := 0; t < 256; t++ {
+		darkCount += hist[t]
+		darkSum += float64(t * hist[t])
+		lightCount := total - darkCount
+		if darkCount == 0 || lightCount == 0 {
+			continue
+		}
+		var (
+			darkMean  = darkSum / float64(darkCount)
+			lightMean = (sum - darkSum) / float64(lightCount)
+			between   = float64(darkCount) * float64(lightCount) * (darkMean - lightMean) * (darkMean - lightMean)
+		)
+		if between > bestVar {
+			best, bestVar = uint8(t), between
+		}
+	}
+	return best
+}
+
+// insideEllipse returns true if the pixel is inside the ellipse which
+// fits in the rect
+func insideEllipse(r image.Rectangle, x, y int) bool {
+	var (
+		dx = (float64(x) + 0.5 - float64(r.Min.X) - float64(r.Dx())/2) / (float64(r.Dx()) / 2)
+		dy = (float64(y) + 0.5 - float64(r.Min.Y) - float64(r.Dy())/2) / (float64(r.Dy()) / 2)
+	)
+	return dx*dx+dy*dy <= 1
+}
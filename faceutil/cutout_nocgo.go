@@ -0,0 +1,12 @@
+//go:build !cgo
+// +build !cgo
+
+package faceutil
+
+import "image"
+
+// cutout fades the crop out to a feathered ellipse. Removing the actual
+// background requires cgo.
+func cutout(img *image.NRGBA, face image.Rectangle) *image.NRGBA {
+	return featherMask(img, 0.2)
+}
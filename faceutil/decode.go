@@ -0,0 +1,53 @@
+package faceutil
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// DecodeImage decodes an image and normalizes it so every source looks the
+// same to the detector and renderer. JPEGs are rotated according to their
+// EXIF orientation, and paletted, grayscale, CMYK and 16-bit images are
+// converted to NRGBA.
+func DecodeImage(r io.Reader) (*image.NRGBA, error) {
+	img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	return toNRGBA(img), nil
+}
+
+// OpenImage decodes the image file with DecodeImage
+func OpenImage(file string) (*image.NRGBA, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeImage(f)
+}
+
+// writePNG encodes the image to a png file
+func writePNG(file string, img image.Image) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// toNRGBA converts the image to NRGBA with its bounds starting at the origin
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	return imaging.Clone(img)
+}
@@ -0,0 +1,204 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Pose is the direction a face is looking
+type Pose int
+
+const (
+	PoseFrontal Pose = iota
+	PoseLeft         // turned towards the left edge of the image
+	PoseRight        // turned towards the right edge of the image
+)
+
+func (p Pose) String() string {
+	switch p {
+	case PoseFrontal:
+		return "frontal"
+	case PoseLeft:
+		return "left"
+	case PoseRight:
+		return "right"
+	default:
+		return "invalid"
+	}
+}
+
+// ParsePose parses the name of a pose. An empty name is frontal.
+func ParsePose(s string) (Pose, error) {
+	switch s {
+	case "", "frontal":
+		return PoseFrontal, nil
+	case "left":
+		return PoseLeft, nil
+	case "right":
+		return PoseRight, nil
+	default:
+		return 0, fmt.Errorf("invalid pose: %s", s)
+	}
+}
+
+// Mirror returns the pose of the horizontally flipped face
+func (p Pose) Mirror() Pose {
+	switch p {
+	case PoseLeft:
+		return PoseRight
+	case PoseRight:
+		return PoseLeft
+	default:
+		return p
+	}
+}
+
+// Detection is a single face found by a Detector
+type Detection struct {
+	Rect image.Rectangle
+
+	// Score is the confidence of the detector. For the cascade detectors
+	// it's the number of neighboring windows merged into the detection.
+	Score float64
+
+	// Weight is the highest last stage sum of the merged windows.
+	// Only the go detector sets it.
+	Weight float64
+
+	// Pose and Source are set by the EnsembleDetector
+	Pose   Pose
+	Source string
+
+	// Angle is the tilt of the face in degrees counter-clockwise.
+	// The face is Rect rotated around its center by Angle.
+	Angle float64
+}
+
+// Detector finds faces in an image
+type Detector interface {
+	Detect(img image.Image) []Detection
+}
+
+// CascadeOptions are the parameters of the cascade detectors
+type CascadeOptions struct {
+	// MinNeighbor is the number of overlapping windows required
+	// for a detection.
+	MinNeighbor int
+
+	// ScaleFactor is how much the window grows between scales
+	ScaleFactor float64
+
+	// MinSize and MaxSize limit the width of the detections in
+	// pixels. Zero means no limit.
+	MinSize int
+	MaxSize int
+}
+
+// scaled returns the options for an image which was resized by scale.
+// The size limits are scaled so they stay in the original's pixels.
+func (o CascadeOptions) scaled(scale float64) CascadeOptions {
+	if o.MinSize > 0 {
+		o.MinSize = maxInt(round(float64(o.MinSize)*scale), 1)
+	}
+	if o.MaxSize > 0 {
+		o.MaxSize = maxInt(round(float64(o.MaxSize)*scale), 1)
+	}
+	return o
+}
+
+// resizedDetector is a Detector which can run on a resized copy of an
+// image without changing which face sizes it looks for
+type resizedDetector interface {
+	detectResized(img image.Image, scale float64) []Detection
+}
+
+// detectResized runs the detector on an image which was resized by scale.
+// Detectors which aren't a resizedDetector use their limits unchanged.
+func detectResized(d Detector, img image.Image, scale float64) []Detection {
+	if rd, ok := d.(resizedDetector); ok {
+		return rd.detectResized(img, scale)
+	}
+	return d.Detect(img)
+}
+
+// DetectorOptions configure the detector created by LoadDetector
+type DetectorOptions struct {
+	// Name is the detector used for the cascades ("opencv" or "go")
+	Name string
+
+	// Cascade is the Haar cascade XML file. It isn't used when
+	// Ensemble is set.
+	Cascade        string
+	CascadeOptions CascadeOptions
+
+	// PoolSize is the number of cascades the opencv detector
+	// can use concurrently
+	PoolSize int
+
+	// Ensemble are the names of the cascades to combine. They're
+	// loaded from CascadeDir.
+	Ensemble   []string
+	CascadeDir string
+
+	// Overlap is the maximum intersection over union between the
+	// faces found by the ensemble, rotation, and scaling detectors.
+	Overlap float64
+
+	// Rotations are the angles to rotate the image by when looking
+	// for tilted faces.
+	Rotations []float64
+
+	// Size is the longest side of the working copy of the image and
+	// Upscale is the factor the crops used to find small faces are
+	// upscaled by. Zero disables them.
+	Size    int
+	Upscale float64
+}
+
+// LoadDetector creates the detector described by the options.
+// When Ensemble is set, an EnsembleDetector is created which uses
+// the named detector for each of its cascades. When Rotations is
+// set, the detector is wrapped in a RotatingDetector. When Size or
+// Upscale are set, it's wrapped in a ScalingDetector.
+func LoadDetector(opt DetectorOptions) (Detector, error) {
+	var (
+		d   Detector
+		err error
+	)
+	if len(opt.Ensemble) == 0 {
+		d, err = loadCascadeDetector(opt.Name, opt.Cascade, opt.CascadeOptions, opt.PoolSize)
+	} else {
+		d, err = loadEnsembleDetector(opt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Rotations) > 0 {
+		d = NewRotatingDetector(d, opt.Rotations, opt.Overlap)
+	}
+	if opt.Size > 0 || opt.Upscale > 1 {
+		d = NewScalingDetector(d, opt.Size, opt.Upscale, opt.Overlap)
+	}
+	return d, nil
+}
+
+func loadCascadeDetector(name, cascadeFile string, opt CascadeOptions, poolSize int) (Detector, error) {
+	switch name {
+	case "opencv":
+		return NewHaarDetector(cascadeFile, opt, poolSize)
+	case "go":
+		return NewCascadeDetector(cascadeFile, opt)
+	default:
+		return nil, fmt.Errorf("invalid detector: %s", name)
+	}
+}
+
+func MustLoadDetector(opt DetectorOptions) Detector {
+	d, err := LoadDetector(opt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d
+}
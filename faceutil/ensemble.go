@@ -0,0 +1,124 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+// ensembleCascades are the cascades which can be used in an ensemble.
+// The profile cascade only finds faces looking left, so faces looking
+// right are found by running it on a mirrored copy of the image.
+var ensembleCascades = map[string]struct {
+	file   string
+	pose   Pose
+	mirror bool
+}{
+	"alt":            {"haarcascade_frontalface_alt.xml", PoseFrontal, false},
+	"alt2":           {"haarcascade_frontalface_alt2.xml", PoseFrontal, false},
+	"default":        {"haarcascade_frontalface_default.xml", PoseFrontal, false},
+	"profile":        {"haarcascade_profileface.xml", PoseLeft, false},
+	"profile.mirror": {"haarcascade_profileface.xml", PoseRight, true},
+}
+
+// EnsembleMember is one of the detectors used by an EnsembleDetector
+type EnsembleMember struct {
+	Name     string
+	Detector Detector
+	Pose     Pose
+
+	// Mirror runs the detector on a horizontally flipped image
+	Mirror bool
+}
+
+// EnsembleDetector runs several detectors on the same image and merges
+// their output using non-maximum suppression.
+type EnsembleDetector struct {
+	members   []EnsembleMember
+	threshold float64
+}
+
+func NewEnsembleDetector(threshold float64, members ...EnsembleMember) *EnsembleDetector {
+	return &EnsembleDetector{
+		members:   members,
+		threshold: threshold,
+	}
+}
+
+func loadEnsembleDetector(opt DetectorOptions) (*EnsembleDetector, error) {
+	d := NewEnsembleDetector(opt.Overlap)
+	for _, cascadeName := range opt.Ensemble {
+		c, ok := ensembleCascades[cascadeName]
+		if !ok {
+			d.Close()
+			return nil, fmt.Errorf("invalid cascade: %s", cascadeName)
+		}
+		file := filepath.Join(opt.CascadeDir, c.file)
+		member, err := loadCascadeDetector(opt.Name, file, opt.CascadeOptions, opt.PoolSize)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("ensemble: %s cascade: %s", cascadeName, err)
+		}
+		d.members = append(d.members, EnsembleMember{
+			Name:     cascadeName,
+			Detector: member,
+			Pose:     c.pose,
+			Mirror:   c.mirror,
+		})
+	}
+	return d, nil
+}
+
+func (d *EnsembleDetector) Detect(i image.Image) []Detection {
+	return d.detectResized(i, 1)
+}
+
+func (d *EnsembleDetector) detectResized(i image.Image, scale float64) []Detection {
+	var (
+		faces    []Detection
+		mirrored image.Image
+		bounds   = i.Bounds()
+	)
+	for _, m := range d.members {
+		if !m.Mirror {
+			for _, face := range detectResized(m.Detector, i, scale) {
+				face.Pose = m.Pose
+				face.Source = m.Name
+				faces = append(faces, face)
+			}
+			continue
+		}
+		if mirrored == nil {
+			mirrored = imaging.FlipH(i)
+		}
+		for _, face := range detectResized(m.Detector, mirrored, scale) {
+			// the flipped image starts at the origin
+			face.Rect = image.Rect(
+				bounds.Max.X-face.Rect.Max.X,
+				bounds.Min.Y+face.Rect.Min.Y,
+				bounds.Max.X-face.Rect.Min.X,
+				bounds.Min.Y+face.Rect.Max.Y,
+			)
+			face.Pose = m.Pose
+			face.Source = m.Name
+			faces = append(faces, face)
+		}
+	}
+	return SuppressOverlaps(faces, d.threshold)
+}
+
+// Close closes all the member detectors
+func (d *EnsembleDetector) Close() error {
+	var err error
+	for _, m := range d.members {
+		if c, ok := m.Detector.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package faceutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Annotation lists the ground truth faces in an image.
+// The boxes are in the coordinates of the image after its
+// EXIF orientation has been applied.
+type Annotation struct {
+	Image string `json:"image"`
+	Faces []Box  `json:"faces"`
+}
+
+// Box is a face rectangle
+type Box struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+func (b Box) Rect() image.Rectangle {
+	return image.Rect(b.X, b.Y, b.X+b.W, b.Y+b.H)
+}
+
+// LoadAnnotations reads the ground truth from a JSON file containing an
+// array of annotations, or from a JSONL file with one annotation per line.
+func LoadAnnotations(file string) ([]Annotation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var annotations []Annotation
+	if filepath.Ext(file) != ".jsonl" {
+		if err := json.NewDecoder(f).Decode(&annotations); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		return annotations, nil
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var a Annotation
+		if err := json.Unmarshal([]byte(line), &a); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, scanner.Err()
+}
+
+// ImageEval is the evaluation of a single image
+type ImageEval struct {
+	Image    string
+	Truth    int
+	Detected int
+
+	// Misses are the ground truth faces which weren't detected and
+	// FalsePositives are the detections which didn't match a face.
+	Misses         []image.Rectangle
+	FalsePositives []image.Rectangle
+}
+
+// Evaluation is the detector's accuracy over a set of annotated images
+type Evaluation struct {
+	Images []ImageEval
+
+	TruePositives  int
+	FalsePositives int
+	FalseNegatives int
+
+	// IoUSum is the sum of the intersection over union of the matches
+	IoUSum float64
+}
+
+func (e *Evaluation) Precision() float64 {
+	return ratio(e.TruePositives, e.TruePositives+e.FalsePositives)
+}
+
+func (e *Evaluation) Recall() float64 {
+	return ratio(e.TruePositives, e.TruePositives+e.FalseNegatives)
+}
+
+// F1 is the harmonic mean of the precision and recall
+func (e *Evaluation) F1() float64 {
+	p, r := e.Precision(), e.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+// MeanIoU is the average intersection over union of the matched faces
+func (e *Evaluation) MeanIoU() float64 {
+	if e.TruePositives == 0 {
+		return 0
+	}
+	return e.IoUSum / float64(e.TruePositives)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Evaluate runs the renderer's Detect on each annotated image in dir and
+// compares the results to the ground truth. A detection matches a face when
+// their intersection over union is at least threshold.
+func Evaluate(r *Renderer, dir string, annotations []Annotation, threshold float64) (*Evaluation, error) {
+	var e Evaluation
+	for _, a := range annotations {
+		img, err := OpenImage(filepath.Join(dir, a.Image))
+		if err != nil {
+			return nil, err
+		}
+		var truth, detected []image.Rectangle
+		for _, b := range a.Faces {
+			truth = append(truth, b.Rect())
+		}
+		faces, _ := r.Detect(img)
+		for _, face := range faces {
+			detected = append(detected, face.Rect)
+		}
+		var (
+			matches = matchFaces(truth, detected, threshold)
+			result  = ImageEval{
+				Image:    a.Image,
+				Truth:    len(truth),
+				Detected: len(detected),
+			}
+			matchedTruth    = map[int]bool{}
+			matchedDetected = map[int]bool{}
+		)
+		for _, m := range matches {
+			matchedTruth[m.truth] = true
+			matchedDetected[m.detected] = true
+			e.IoUSum += m.iou
+		}
+		for i, r := range truth {
+			if !matchedTruth[i] {
+				result.Misses = append(result.Misses, r)
+			}
+		}
+		for i, r := range detected {
+			if !matchedDetected[i] {
+				result.FalsePositives = append(result.FalsePositives, r)
+			}
+		}
+		e.TruePositives += len(matches)
+		e.FalseNegatives += len(result.Misses)
+		e.FalsePositives += len(result.FalsePositives)
+		e.Images = append(e.Images, result)
+	}
+	return &e, nil
+}
+
+type faceMatch struct {
+	truth    int
+	detected int
+	iou      float64
+}
+
+// matchFaces greedily pairs the ground truth and detected faces
+// starting with the pairs that overlap the most.
+func matchFaces(truth, detected []image.Rectangle, threshold float64) []faceMatch {
+	var pairs []faceMatch
+	for i, t := range truth {
+		for j, d := range detected {
+			if iou := IntersectionOverUnion(t, d); iou >= threshold && iou > 0 {
+				pairs = append(pairs, faceMatch{truth: i, detected: j, iou: iou})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].iou > pairs[j].iou
+	})
+	var (
+		matches         []faceMatch
+		matchedTruth    = map[int]bool{}
+		matchedDetected = map[int]bool{}
+	)
+	for _, p := range pairs {
+		if matchedTruth[p.truth] || matchedDetected[p.detected] {
+			continue
+		}
+		matchedTruth[p.truth] = true
+		matchedDetected[p.detected] = true
+		matches = append(matches, p)
+	}
+	return matches
+}
@@ -0,0 +1,226 @@
+package faceutil
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/disintegration/imaging"
+)
+
+// FitMode is how photos outside the allowed aspect ratios are fixed
+type FitMode int
+
+const (
+	// FitCrop crops the photo around the faces. Photos where the faces
+	// can't all be kept are padded instead.
+	FitCrop FitMode = iota
+
+	// FitPad pads the photo with a blurred copy of itself
+	FitPad
+)
+
+func (m FitMode) String() string {
+	switch m {
+	case FitCrop:
+		return "crop"
+	case FitPad:
+		return "pad"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (m *FitMode) Set(s string) error {
+	mode, err := ParseFitMode(s)
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+func ParseFitMode(s string) (FitMode, error) {
+	switch s {
+	case "crop":
+		return FitCrop, nil
+	case "pad":
+		return FitPad, nil
+	default:
+		return 0, fmt.Errorf("invalid fit mode: %s", s)
+	}
+}
+
+// ExportOptions configure how photos are prepared for uploading
+type ExportOptions struct {
+	// MinAspect and MaxAspect are the allowed range of width to
+	// height ratios. Zero is unlimited.
+	MinAspect float64
+	MaxAspect float64
+
+	Fit FitMode
+
+	// Width is the width of the exported photo. Zero keeps the
+	// original width.
+	Width int
+
+	// MaxBytes is the largest the JPEG can be. The highest quality
+	// between MinQuality and Quality which fits is used. Zero is
+	// unlimited.
+	MaxBytes   int
+	MinQuality int
+	Quality    int
+
+	// Caption is drawn on the photo with Text after it's fitted to the
+	// aspect ratios so it isn't cropped off. Nothing is drawn when it's
+	// empty.
+	Caption string
+	Text    TextOptions
+}
+
+// Export fits the rendered image to the allowed aspect ratios, draws the
+// caption, resizes it, and writes it as a JPEG. The faces are the
+// detections the image was rendered with.
+func (r *Renderer) Export(w io.Writer, img image.Image, faces []Detection, opt ExportOptions) error {
+	var (
+		b      = img.Bounds()
+		aspect = float64(b.Dx()) / float64(b.Dy())
+		target = aspect
+	)
+	if opt.MinAspect > 0 && target < opt.MinAspect {
+		target = opt.MinAspect
+	}
+	if opt.MaxAspect > 0 && target > opt.MaxAspect {
+		target = opt.MaxAspect
+	}
+	var (
+		fitted = imaging.Clone(img)
+
+		// shift moves the faces onto the fitted photo
+		shift = image.ZP.Sub(b.Min)
+	)
+	if target != aspect {
+		var (
+			crop image.Rectangle
+			ok   bool
+		)
+		if opt.Fit == FitCrop {
+			crop, ok = r.cropAround(b, faces, target)
+		}
+		if ok {
+			fitted = imaging.Crop(img, crop)
+			shift = image.ZP.Sub(crop.Min)
+		} else {
+			fitted = padBlurred(img, target)
+			shift = image.Pt((fitted.Rect.Dx()-b.Dx())/2, (fitted.Rect.Dy()-b.Dy())/2).Sub(b.Min)
+		}
+	}
+	if opt.Caption != "" {
+		moved := make([]Detection, len(faces))
+		for i, face := range faces {
+			face.Rect = face.Rect.Add(shift)
+			moved[i] = face
+		}
+		fitted = r.DrawText(fitted, moved, opt.Caption, opt.Text)
+	}
+	if opt.Width > 0 && fitted.Rect.Dx() != opt.Width {
+		fitted = imaging.Resize(fitted, opt.Width, 0, imaging.Lanczos)
+	}
+	data, err := encodeJPEG(fitted, opt)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// cropAround returns the largest rect with the target aspect ratio which
+// keeps the padded faces inside it. The crop is centered on the faces as
+// much as the bounds allow. It returns false when the faces don't fit.
+func (r *Renderer) cropAround(b image.Rectangle, faces []Detection, target float64) (image.Rectangle, bool) {
+	var keep image.Rectangle
+	for _, face := range faces {
+		keep = keep.Union(addRectPadding(r.opt.Margin, face.Rect, b).Intersect(b))
+	}
+	if keep.Empty() {
+		keep = image.Rect(0, 0, 1, 1).Add(getRectCenter(b))
+	}
+	crop := cropCentered(b, getRectCenter(keep), target)
+	w, h := crop.Dx(), crop.Dy()
+	if keep.Dx() > w || keep.Dy() > h {
+		return image.Rectangle{}, false
+	}
+	// the centered crop can cut off faces at the edge of the union
+	origin := image.Pt(
+		clampInt(crop.Min.X, keep.Max.X-w, keep.Min.X),
+		clampInt(crop.Min.Y, keep.Max.Y-h, keep.Min.Y),
+	)
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}, true
+}
+
+// padBlurred centers the image on a blurred and enlarged copy of itself
+// with the target aspect ratio
+func padBlurred(img image.Image, target float64) *image.NRGBA {
+	var (
+		b = img.Bounds()
+		w = b.Dx()
+		h = b.Dy()
+	)
+	if float64(w)/float64(h) > target {
+		h = round(float64(w) / target)
+	} else {
+		w = round(float64(h) * target)
+	}
+	background := imaging.Blur(imaging.Fill(img, w, h, imaging.Center, imaging.Linear), float64(maxInt(w, h))/40)
+	return imaging.Overlay(background, img, image.Pt((w-b.Dx())/2, (h-b.Dy())/2), 1)
+}
+
+// encodeJPEG binary searches for the highest quality which fits
+// in the byte budget
+func encodeJPEG(img image.Image, opt ExportOptions) ([]byte, error) {
+	var (
+		lo   = opt.MinQuality
+		hi   = opt.Quality
+		best []byte
+		buf  bytes.Buffer
+	)
+	if hi <= 0 {
+		hi = jpeg.DefaultQuality
+	}
+	if lo <= 0 || lo > hi {
+		lo = hi
+	}
+	lowest := lo
+	encode := func(quality int) ([]byte, error) {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), buf.Bytes()...), nil
+	}
+	if opt.MaxBytes <= 0 {
+		return encode(hi)
+	}
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		data, err := encode(quality)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= opt.MaxBytes {
+			best = data
+			lo = quality + 1
+		} else {
+			hi = quality - 1
+		}
+	}
+	if best == nil {
+		log.Warnf("faceutil: photo is over %d bytes at quality %d", opt.MaxBytes, lowest)
+		return encode(lowest)
+	}
+	return best, nil
+}
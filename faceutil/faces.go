@@ -1,95 +1,198 @@
 package faceutil
 
 import (
+	"fmt"
 	"image"
 	"io/ioutil"
-	"math/rand"
-	"os"
-	"path"
 	"path/filepath"
-	"time"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/disintegration/imaging"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
+// defaultGroupSize is the number of faces in a photo which makes it a
+// group photo when the policy doesn't set one
+const defaultGroupSize = 4
+
+// GroupUse is whether a face can be used in group photos
+type GroupUse int
 
-var (
-	primaryFaceList []image.Image
-	allFaceList     []image.Image
+const (
+	GroupAllow GroupUse = iota // used in any photo
+	GroupOnly                  // only used in group photos
+	GroupNever                 // never used in group photos
 )
 
-func LoadFaces(dir string) error {
-	var err error
-	primaryFaceList, err = loadFaces(filepath.Join(dir, "primary"))
-	if err != nil {
-		return err
+func (g GroupUse) String() string {
+	switch g {
+	case GroupAllow:
+		return "allow"
+	case GroupOnly:
+		return "only"
+	case GroupNever:
+		return "never"
+	default:
+		return "invalid"
+	}
+}
+
+// faceImage is a face which can be drawn over a detected face.
+// The eyes are nil when they're unknown.
+type faceImage struct {
+	name   string
+	img    image.Image
+	pose   Pose
+	eyes   *Eyes
+	tags   []string
+	weight float64
+	group  GroupUse
+
+	// minSize and maxSize are the range of detected face widths
+	// the face is preferred for. Zero means no limit.
+	minSize int
+	maxSize int
+}
+
+// usable returns true if the face can be used in the photo
+func (f *faceImage) usable(group bool) bool {
+	switch f.group {
+	case GroupOnly:
+		return group
+	case GroupNever:
+		return !group
+	default:
+		return true
+	}
+}
+
+// fits returns true if the face is preferred for the detected face width
+func (f *faceImage) fits(width int) bool {
+	return width >= f.minSize && (f.maxSize == 0 || width <= f.maxSize)
+}
+
+// FacePack is a set of face images which can be drawn over detected faces
+type FacePack struct {
+	Name  string
+	faces []faceImage
+}
+
+// LoadFacePack loads a face pack from a zip archive or a directory with a
+// manifest.json file. Directories without a manifest are loaded using the
+// legacy layout where the faces in the primary directory can be used in any
+// photo and the faces in the seconday directory are only used in group photos.
+// The eyes of faces without annotations are found using the eye detector
+// when it isn't nil.
+func LoadFacePack(path string, eyeDetector Detector) (*FacePack, error) {
+	var (
+		pack *FacePack
+		err  error
+	)
+	if strings.HasSuffix(path, ".zip") {
+		pack, err = loadZipPack(path)
+	} else if fileExists(filepath.Join(path, manifestName)) {
+		pack, err = loadDirPack(path)
+	} else {
+		pack, err = loadLegacyPack(path)
 	}
-	secondayFaceList, err := loadFaces(filepath.Join(dir, "seconday"))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, face := range primaryFaceList {
-		allFaceList = append(allFaceList, face)
+	if len(pack.faces) == 0 {
+		return nil, fmt.Errorf("no faces in %s", path)
 	}
-	for _, face := range secondayFaceList {
-		allFaceList = append(allFaceList, face)
+	for i := range pack.faces {
+		face := &pack.faces[i]
+		if face.eyes != nil {
+			continue
+		}
+		if eyes, ok := locateEyes(eyeDetector, face.img, Detection{Rect: face.img.Bounds()}); ok {
+			log.Debugf("faceutil: detected eyes in %s: %v %v", face.name, eyes.Left, eyes.Right)
+			face.eyes = &eyes
+		}
 	}
-	return nil
+	return pack, nil
 }
 
-func MustLoadFaces(dir string) {
-	if err := LoadFaces(dir); err != nil {
+func MustLoadFacePack(path string, eyeDetector Detector) *FacePack {
+	pack, err := LoadFacePack(path, eyeDetector)
+	if err != nil {
 		log.Fatal(err)
 	}
+	return pack
 }
 
-func loadFaces(dir string) ([]image.Image, error) {
+func loadLegacyPack(dir string) (*FacePack, error) {
+	primary, err := loadFaceDir(filepath.Join(dir, "primary"), GroupAllow)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := loadFaceDir(filepath.Join(dir, "seconday"), GroupOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &FacePack{
+		Name:  filepath.Base(dir),
+		faces: append(primary, secondary...),
+	}, nil
+}
+
+// loadFaceDir loads the png images in a legacy pack directory. The eye
+// positions are read from an optional eyes.txt file and the pose is
+// taken from the name.
+func loadFaceDir(dir string, group GroupUse) ([]faceImage, error) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	var faces []image.Image
+	annotations, err := loadEyeAnnotations(filepath.Join(dir, "eyes.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var faces []faceImage
 	for _, file := range files {
 		if filepath.Ext(file.Name()) != ".png" {
 			continue
 		}
-		m, err := loadImage(path.Join(dir, file.Name()))
+		m, err := OpenImage(filepath.Join(dir, file.Name()))
 		if err != nil {
 			return nil, err
 		}
-		faces = append(faces, m)
+		face := faceImage{
+			name:   filepath.Join(filepath.Base(dir), file.Name()),
+			img:    m,
+			pose:   poseFromName(file.Name()),
+			weight: 1,
+			group:  group,
+		}
+		if eyes, ok := annotations[file.Name()]; ok {
+			face.eyes = &eyes
+		}
+		faces = append(faces, face)
 	}
 	return faces, nil
 }
 
-func loadImage(file string) (image.Image, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+func poseFromName(name string) Pose {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	switch {
+	case strings.HasSuffix(name, "_left"):
+		return PoseLeft
+	case strings.HasSuffix(name, "_right"):
+		return PoseRight
+	default:
+		return PoseFrontal
 	}
-	defer f.Close()
-	m, _, err := image.Decode(f)
-	if err != nil {
-		return nil, err
-	}
-	return m, nil
 }
 
-func randomFace(primary bool) image.Image {
-	var faces []image.Image
-	if primary {
-		faces = primaryFaceList
-	} else {
-		faces = allFaceList
-	}
-	i := rand.Intn(len(faces))
-	face := faces[i]
-	if rand.Intn(2) == 0 {
-		return imaging.FlipH(face)
+// flip mirrors the face horizontally
+func (f faceImage) flip() faceImage {
+	flipped := f
+	flipped.img = imaging.FlipH(f.img)
+	flipped.pose = f.pose.Mirror()
+	if f.eyes != nil {
+		eyes := flipEyes(*f.eyes, f.img.Bounds())
+		flipped.eyes = &eyes
 	}
-	return face
+	return flipped
 }
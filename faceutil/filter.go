@@ -0,0 +1,129 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Filter rejects detections which probably aren't faces.
+// Rules with a zero value are disabled.
+type Filter struct {
+	// MinSize is the minimum face width relative to the shorter
+	// side of the image.
+	MinSize float64
+
+	// MinSkin is the minimum fraction of skin colored pixels
+	// inside the face. Grayscale faces are never rejected.
+	MinSkin float64
+
+	// MaxAspect is the maximum ratio of the longer side of the
+	// face to the shorter side.
+	MaxAspect float64
+
+	// MinScore is the minimum detection score
+	MinScore float64
+}
+
+// Rejection is a detection which was dropped by a Filter
+type Rejection struct {
+	Detection
+	Reason string
+}
+
+// Apply returns the faces which passed all the rules and the ones which didn't.
+func (f *Filter) Apply(i image.Image, faces []Detection) ([]Detection, []Rejection) {
+	var (
+		kept     []Detection
+		rejected []Rejection
+	)
+	for _, face := range faces {
+		if reason := f.check(i, face); reason != "" {
+			rejected = append(rejected, Rejection{Detection: face, Reason: reason})
+		} else {
+			kept = append(kept, face)
+		}
+	}
+	return kept, rejected
+}
+
+// check returns why the face was rejected or an empty string
+func (f *Filter) check(i image.Image, face Detection) string {
+	var (
+		b = i.Bounds()
+		w = float64(face.Rect.Dx())
+		h = float64(face.Rect.Dy())
+	)
+	if f.MinScore > 0 && face.Score < f.MinScore {
+		return fmt.Sprintf("score %.0f < %.0f", face.Score, f.MinScore)
+	}
+	if f.MinSize > 0 {
+		if size := w / float64(minInt(b.Dx(), b.Dy())); size < f.MinSize {
+			return fmt.Sprintf("size %.3f < %.3f", size, f.MinSize)
+		}
+	}
+	if f.MaxAspect > 0 && w > 0 && h > 0 {
+		if aspect := math.Max(w, h) / math.Min(w, h); aspect > f.MaxAspect {
+			return fmt.Sprintf("aspect %.2f > %.2f", aspect, f.MaxAspect)
+		}
+	}
+	if f.MinSkin > 0 {
+		if skin, ok := skinRatio(i, face.Rect); ok && skin < f.MinSkin {
+			return fmt.Sprintf("skin %.2f < %.2f", skin, f.MinSkin)
+		}
+	}
+	return ""
+}
+
+// skinRatio returns the fraction of pixels inside the rect which are skin
+// colored. It returns false when the rect is grayscale, because the colors
+// don't say anything about it.
+func skinRatio(i image.Image, r image.Rectangle) (float64, bool) {
+	r = r.Intersect(i.Bounds())
+	if r.Empty() {
+		return 0, false
+	}
+	var skin, colored, total int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			rr, gg, bb, _ := i.At(x, y).RGBA()
+			_, cb, cr := color.RGBToYCbCr(uint8(rr>>8), uint8(gg>>8), uint8(bb>>8))
+			// skin tones fall inside a fixed range of chroma values
+			// regardless of how dark they are.
+			if cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173 {
+				skin++
+			}
+			if absInt(int(cb)-128)+absInt(int(cr)-128) > 6 {
+				colored++
+			}
+			total++
+		}
+	}
+	if colored*20 < total {
+		return 0, false
+	}
+	return float64(skin) / float64(total), true
+}
+
+// drawLabel draws the text with its baseline starting at p
+func drawLabel(canvas *image.NRGBA, text string, p image.Point, c color.Color) {
+	d := font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(p.X, p.Y),
+	}
+	d.DrawString(text)
+}
+
+func logRejections(rejected []Rejection) {
+	for _, r := range rejected {
+		log.Debugf("faceutil: rejected face at %v: %s", r.Rect, r.Reason)
+	}
+}
@@ -0,0 +1,284 @@
+package faceutil
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Transition is how a before and after animation goes from the
+// original photo to the nicked one
+type Transition int
+
+const (
+	// TransitionFade cross fades the whole photo
+	TransitionFade Transition = iota
+
+	// TransitionPopIn draws the faces one at a time from
+	// the top of the photo to the bottom
+	TransitionPopIn
+)
+
+func (t Transition) String() string {
+	switch t {
+	case TransitionFade:
+		return "fade"
+	case TransitionPopIn:
+		return "popin"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (t *Transition) Set(s string) error {
+	transition, err := ParseTransition(s)
+	if err != nil {
+		return err
+	}
+	*t = transition
+	return nil
+}
+
+func ParseTransition(s string) (Transition, error) {
+	switch s {
+	case "fade":
+		return TransitionFade, nil
+	case "popin":
+		return TransitionPopIn, nil
+	default:
+		return 0, fmt.Errorf("invalid transition: %s", s)
+	}
+}
+
+// GIFOptions configure a before and after animation
+type GIFOptions struct {
+	Transition Transition
+
+	// Frames is the number of frames in a fade
+	Frames int
+
+	// Delay is the time between the frames of the transition and
+	// Hold is how long the before and after frames are shown
+	Delay time.Duration
+	Hold  time.Duration
+
+	// Colors is the size of the palette shared by all the frames
+	Colors int
+
+	// MaxSize is the longest side of the animation in pixels and
+	// MaxBytes is the largest the encoded animation can be. The
+	// animation is scaled down until it fits. Zero is unlimited.
+	MaxSize  int
+	MaxBytes int
+}
+
+// WriteGIF replaces the faces in the image and writes an animation which
+// goes from the original image to the one with the faces replaced.
+func (r *Renderer) WriteGIF(w io.Writer, i image.Image, opt GIFOptions) error {
+	// the frames must all start at the origin
+	i = imaging.Clone(i)
+	faces, rejected := r.Detect(i)
+	var (
+		before = canvasFromImage(i)
+		frames []*image.NRGBA
+		delays []int
+		delay  = centiseconds(opt.Delay)
+		hold   = centiseconds(opt.Hold)
+	)
+	frames = append(frames, before)
+	delays = append(delays, hold)
+	switch opt.Transition {
+	case TransitionPopIn:
+		var (
+			s            = r.NewSelector(nil)
+			canvas       = imaging.Clone(before)
+			modes, group = r.faceModes(faces, len(faces))
+		)
+		// the faces are sorted from top to bottom by Detect
+		for i, face := range faces {
+			if modes[i] == nil {
+				continue
+			}
+			canvas = r.replaceFace(s, canvas, face, modes[i], group[i])
+			frames = append(frames, imaging.Clone(canvas))
+			delays = append(delays, delay)
+		}
+		frames[len(frames)-1] = r.DrawRejections(frames[len(frames)-1], rejected)
+	default:
+		after := r.DrawRejections(r.Draw(i, faces), rejected)
+		for f := 1; f < opt.Frames; f++ {
+			alpha := float64(f) / float64(opt.Frames)
+			frames = append(frames, imaging.Overlay(before, after, image.Pt(0, 0), alpha))
+			delays = append(delays, delay)
+		}
+		frames = append(frames, after)
+		delays = append(delays, delay)
+	}
+	delays[len(delays)-1] = hold
+
+	var (
+		buf   bytes.Buffer
+		size  = maxInt(before.Rect.Dx(), before.Rect.Dy())
+		scale = 1.0
+	)
+	if opt.MaxSize > 0 && size > opt.MaxSize {
+		scale = float64(opt.MaxSize) / float64(size)
+	}
+	for {
+		g := encodeFrames(frames, delays, scale, opt.Colors)
+		buf.Reset()
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			return err
+		}
+		// give up shrinking once the animation is tiny
+		if opt.MaxBytes <= 0 || buf.Len() <= opt.MaxBytes || float64(size)*scale < 64 {
+			break
+		}
+		scale *= 0.8
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// encodeFrames scales the frames and dithers them with a shared palette
+func encodeFrames(frames []*image.NRGBA, delays []int, scale float64, colors int) *gif.GIF {
+	var scaled []*image.NRGBA
+	for _, frame := range frames {
+		if scale < 1 {
+			w := maxInt(round(float64(frame.Rect.Dx())*scale), 1)
+			frame = imaging.Resize(frame, w, 0, imaging.Lanczos)
+		}
+		scaled = append(scaled, frame)
+	}
+	// every frame is a mix of the first and last frames
+	palette := medianCut([]*image.NRGBA{scaled[0], scaled[len(scaled)-1]}, colors)
+	g := &gif.GIF{Delay: delays}
+	for _, frame := range scaled {
+		p := image.NewPaletted(frame.Rect, palette)
+		draw.FloydSteinberg.Draw(p, frame.Rect, frame, frame.Rect.Min)
+		g.Image = append(g.Image, p)
+	}
+	return g
+}
+
+// medianCut builds a palette of at most n colors by repeatedly splitting
+// the box of colors with the widest range at its median. Mostly transparent
+// pixels are ignored.
+func medianCut(imgs []*image.NRGBA, n int) color.Palette {
+	if n < 1 || n > 256 {
+		n = 256
+	}
+	var pixels []color.NRGBA
+	for _, img := range imgs {
+		// sample the pixels so large photos don't take forever
+		step := maxInt(len(img.Pix)/4/(1<<15), 1)
+		for i := 0; i+3 < len(img.Pix); i += step * 4 {
+			if img.Pix[i+3] < 128 {
+				continue
+			}
+			pixels = append(pixels, color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255})
+		}
+	}
+	boxes := [][]color.NRGBA{pixels}
+	for len(boxes) < n {
+		var (
+			widest  = -1
+			channel int
+			span    uint8
+		)
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, s := widestChannel(box); widest == -1 || s > span {
+				widest, channel, span = i, c, s
+			}
+		}
+		if widest == -1 || span == 0 {
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool {
+			return channelOf(box[i], channel) < channelOf(box[j], channel)
+		})
+		mid := len(box) / 2
+		boxes[widest] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+	var palette color.Palette
+	for _, box := range boxes {
+		if len(box) == 0 {
+			continue
+		}
+		var r, g, b int
+		for _, c := range box {
+			r += int(c.R)
+			g += int(c.G)
+			b += int(c.B)
+		}
+		palette = append(palette, color.NRGBA{
+			uint8(r / len(box)),
+			uint8(g / len(box)),
+			uint8(b / len(box)),
+			255,
+		})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.Black)
+	}
+	return palette
+}
+
+// widestChannel returns the channel with the largest range in the box
+func widestChannel(box []color.NRGBA) (int, uint8) {
+	var (
+		lo = [3]uint8{255, 255, 255}
+		hi [3]uint8
+	)
+	for _, c := range box {
+		for ch := 0; ch < 3; ch++ {
+			v := channelOf(c, ch)
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+	var (
+		widest int
+		span   uint8
+	)
+	for ch := 0; ch < 3; ch++ {
+		if hi[ch] >= lo[ch] && hi[ch]-lo[ch] > span {
+			widest, span = ch, hi[ch]-lo[ch]
+		}
+	}
+	return widest, span
+}
+
+func channelOf(c color.NRGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	default:
+		return c.B
+	}
+}
+
+// centiseconds converts a duration to the unit used by gif delays
+func centiseconds(d time.Duration) int {
+	return int(d / (10 * time.Millisecond))
+}
@@ -0,0 +1,63 @@
+package faceutil
+
+import (
+	"image"
+	"image/color"
+)
+
+// goldenDiffThreshold is how much a channel can change before the pixel
+// counts as changed
+const goldenDiffThreshold = 8
+
+// DiffImages returns the fraction of pixels which changed and an image of
+// the expected render faded to gray with the changed pixels in red. Images
+// with different sizes are completely changed.
+func DiffImages(expected, actual image.Image) (float64, *image.NRGBA) {
+	var (
+		b    = expected.Bounds()
+		diff = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		red  = color.NRGBA{255, 0, 0, 255}
+	)
+	if actual.Bounds().Size() != b.Size() {
+		for i := 3; i < len(diff.Pix); i += 4 {
+			diff.Pix[i-3], diff.Pix[i] = 255, 255
+		}
+		return 1, diff
+	}
+	if b.Empty() {
+		return 0, diff
+	}
+	var (
+		changed int
+		offset  = actual.Bounds().Min.Sub(b.Min)
+	)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var (
+				c1 = color.NRGBAModel.Convert(expected.At(x, y)).(color.NRGBA)
+				c2 = color.NRGBAModel.Convert(actual.At(x+offset.X, y+offset.Y)).(color.NRGBA)
+				dx = x - b.Min.X
+				dy = y - b.Min.Y
+			)
+			if channelDiff(c1.R, c2.R) > goldenDiffThreshold ||
+				channelDiff(c1.G, c2.G) > goldenDiffThreshold ||
+				channelDiff(c1.B, c2.B) > goldenDiffThreshold ||
+				channelDiff(c1.A, c2.A) > goldenDiffThreshold {
+				changed++
+				diff.SetNRGBA(dx, dy, red)
+				continue
+			}
+			gray := color.GrayModel.Convert(c1).(color.Gray).Y
+			faded := uint8(128 + int(gray)/2)
+			diff.SetNRGBA(dx, dy, color.NRGBA{faded, faded, faded, 255})
+		}
+	}
+	return float64(changed) / float64(b.Dx()*b.Dy()), diff
+}
+
+func channelDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,112 @@
+package faceutil
+
+import (
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("golden.update", false, "replace the expected renders instead of checking them")
+
+const (
+	goldenDir       = "testdata/golden"
+	goldenSeed      = 1
+	goldenTolerance = 0.001
+)
+
+// goldenRenderOptions are the default flags with the go detector, which
+// is what the expected renders are made with. The opencv detector finds
+// slightly different rects, so it isn't used even when cgo is enabled.
+func goldenRenderOptions(t *testing.T) RenderOptions {
+	detector, err := NewCascadeDetector("../haarcascade_frontalface_alt.xml", CascadeOptions{
+		MinNeighbor: 9,
+		ScaleFactor: 1.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces, err := LoadFacePack("../faces", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes, err := ParseModeRules("overlay", ModeOptions{BlurSigma: 0.08, Blocks: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RenderOptions{
+		Detector: detector,
+		Faces:    faces,
+		Overlap:  0.3,
+		Filter:   &Filter{},
+		Margin:   60,
+		Opacity:  1,
+		Blend: BlendOptions{
+			Mode:       BlendOverlay,
+			Feather:    0.2,
+			Iterations: 300,
+		},
+		Modes:    modes,
+		DrawFace: true,
+	}
+}
+
+// TestGoldenRenders renders the images in testdata/golden and compares
+// them to the expected renders. A diff image is written next to the
+// expected render when they don't match. Run with -golden.update to
+// accept intended changes.
+func TestGoldenRenders(t *testing.T) {
+	entries, err := ioutil.ReadDir(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := goldenRenderOptions(t)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		var (
+			name     = strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			expected = filepath.Join(goldenDir, "expected", name+".png")
+			diffFile = filepath.Join(goldenDir, "expected", name+".diff.png")
+		)
+		t.Run(name, func(t *testing.T) {
+			img, err := OpenImage(filepath.Join(goldenDir, e.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// every image gets its own source so the renders don't
+			// depend on which images come before them.
+			opt.Rand = rand.NewSource(goldenSeed)
+			r, err := NewRenderer(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := r.Replace(img)
+			if *updateGolden {
+				if err := writePNG(expected, actual); err != nil {
+					t.Fatal(err)
+				}
+				os.Remove(diffFile)
+				return
+			}
+			want, err := OpenImage(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, diff := DiffImages(want, actual)
+			if changed <= goldenTolerance {
+				os.Remove(diffFile)
+				return
+			}
+			if err := writePNG(diffFile, diff); err != nil {
+				t.Fatal(err)
+			}
+			t.Errorf("%.4f of the pixels changed, see %s", changed, diffFile)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+//go:build cgo
+// +build cgo
+
+package faceutil
+
+//#include <stdlib.h>
+//#include <string.h>
+//#include <opencv/cv.h>
+//#cgo linux  pkg-config: opencv
+//#cgo darwin pkg-config: opencv
+//#cgo freebsd pkg-config: opencv
+import "C"
+import (
+	"fmt"
+	"image"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// DefaultDetector is the detector used when none is specified
+const DefaultDetector = "opencv"
+
+// HaarDetector uses the OpenCV Haar cascade classifier.
+// The cascade is loaded once for each slot in the pool. OpenCV caches
+// per-scale data inside the cascade, so a cascade can only be used by one
+// goroutine at a time and Detect blocks until one is available.
+type HaarDetector struct {
+	opt       CascadeOptions
+	pool      chan *C.CvHaarClassifierCascade
+	closeOnce sync.Once
+}
+
+func NewHaarDetector(cascadeFile string, opt CascadeOptions, poolSize int) (*HaarDetector, error) {
+	if _, err := os.Stat(cascadeFile); err != nil {
+		return nil, err
+	}
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	if opt.ScaleFactor <= 1 {
+		return nil, fmt.Errorf("invalid scale factor: %g", opt.ScaleFactor)
+	}
+	d := &HaarDetector{
+		opt:  opt,
+		pool: make(chan *C.CvHaarClassifierCascade, poolSize),
+	}
+	filename := C.CString(cascadeFile)
+	defer C.free(unsafe.Pointer(filename))
+	for i := 0; i < poolSize; i++ {
+		cascade := C.cvLoadHaarClassifierCascade(filename, C.cvSize(1, 1))
+		if cascade == nil {
+			close(d.pool)
+			for cascade := range d.pool {
+				C.cvReleaseHaarClassifierCascade(&cascade)
+			}
+			return nil, fmt.Errorf("failed to load cascade: %s", cascadeFile)
+		}
+		d.pool <- cascade
+	}
+	return d, nil
+}
+
+func (d *HaarDetector) Detect(i image.Image) []Detection {
+	return d.detectResized(i, 1)
+}
+
+func (d *HaarDetector) detectResized(i image.Image, scale float64) []Detection {
+	if i.Bounds().Empty() {
+		return nil
+	}
+	opt := d.opt.scaled(scale)
+
+	cascade := <-d.pool
+	defer func() { d.pool <- cascade }()
+
+	img := newGrayIplImage(toGray(i))
+	defer C.cvReleaseImage(&img)
+
+	storage := C.cvCreateMemStorage(0)
+	defer C.cvReleaseMemStorage(&storage)
+
+	seq := C.cvHaarDetectObjects(
+		unsafe.Pointer(img), cascade, storage,
+		C.double(opt.ScaleFactor), C.int(opt.MinNeighbor), C.CV_HAAR_DO_CANNY_PRUNING,
+		C.cvSize(C.int(opt.MinSize), C.int(opt.MinSize)),
+		C.cvSize(C.int(opt.MaxSize), C.int(opt.MaxSize)),
+	)
+
+	// the results live in the storage, so they're copied out before it's released
+	var (
+		output []Detection
+		offset = i.Bounds().Min
+	)
+	for k := 0; k < int(seq.total); k++ {
+		comp := (*C.CvAvgComp)(unsafe.Pointer(C.cvGetSeqElem(seq, C.int(k))))
+		x, y := int(comp.rect.x), int(comp.rect.y)
+		output = append(output, Detection{
+			Rect:  image.Rect(x, y, x+int(comp.rect.width), y+int(comp.rect.height)).Add(offset),
+			Score: float64(comp.neighbors),
+		})
+	}
+	return output
+}
+
+// Close waits for in-flight detections to finish and releases the cascades.
+// The detector must not be used afterwards.
+func (d *HaarDetector) Close() error {
+	d.closeOnce.Do(func() {
+		for i := 0; i < cap(d.pool); i++ {
+			cascade := <-d.pool
+			C.cvReleaseHaarClassifierCascade(&cascade)
+		}
+	})
+	return nil
+}
+
+// newGrayIplImage copies a grayscale image into memory allocated by OpenCV.
+// The caller must release it with cvReleaseImage.
+func newGrayIplImage(gray *image.Gray) *C.IplImage {
+	var (
+		w   = gray.Rect.Dx()
+		h   = gray.Rect.Dy()
+		img = C.cvCreateImage(C.cvSize(C.int(w), C.int(h)), C.IPL_DEPTH_8U, 1)
+	)
+	for y := 0; y < h; y++ {
+		var (
+			src = unsafe.Pointer(&gray.Pix[y*gray.Stride])
+			dst = unsafe.Pointer(uintptr(unsafe.Pointer(img.imageData)) + uintptr(y*int(img.widthStep)))
+		)
+		C.memcpy(dst, src, C.size_t(w))
+	}
+	return img
+}
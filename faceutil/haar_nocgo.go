@@ -0,0 +1,27 @@
+//go:build !cgo
+// +build !cgo
+
+package faceutil
+
+import (
+	"errors"
+	"image"
+)
+
+// DefaultDetector is the detector used when none is specified
+const DefaultDetector = "go"
+
+// HaarDetector is unavailable without cgo
+type HaarDetector struct{}
+
+func NewHaarDetector(cascadeFile string, opt CascadeOptions, poolSize int) (*HaarDetector, error) {
+	return nil, errors.New("the opencv detector requires cgo")
+}
+
+func (d *HaarDetector) Detect(i image.Image) []Detection {
+	return nil
+}
+
+func (d *HaarDetector) Close() error {
+	return nil
+}
@@ -0,0 +1,137 @@
+//go:build cgo
+// +build cgo
+
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestHaarDetector(t testing.TB, poolSize int) *HaarDetector {
+	d, err := NewHaarDetector("../haarcascade_frontalface_alt.xml", CascadeOptions{
+		MinNeighbor: 9,
+		ScaleFactor: 1.1,
+	}, poolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func openTestImage(t testing.TB) image.Image {
+	img, err := OpenImage("testdata/golden/lena.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestHaarDetectorConcurrent(t *testing.T) {
+	var (
+		d    = newTestHaarDetector(t, 2)
+		img  = openTestImage(t)
+		want = d.Detect(img)
+		wg   sync.WaitGroup
+	)
+	defer d.Close()
+	if len(want) != 1 {
+		t.Fatalf("found %d face(s), want 1", len(want))
+	}
+
+	// more goroutines than cascades, so some of them wait for one
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got := d.Detect(img)
+			if len(got) != len(want) || got[0] != want[0] {
+				errs <- fmt.Errorf("got %v, want %v", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHaarDetectorClose(t *testing.T) {
+	d := newTestHaarDetector(t, 2)
+
+	// holding a cascade is what an in-flight detection does, so Close
+	// has to wait until it's given back
+	var (
+		cascade = <-d.pool
+		closed  = make(chan error)
+	)
+	go func() { closed <- d.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a cascade was in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	d.pool <- cascade
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't return after the cascade was given back")
+	}
+	if len(d.pool) != 0 {
+		t.Fatalf("%d cascade(s) left in the pool", len(d.pool))
+	}
+
+	// closing again doesn't wait for the released cascades
+	go func() { closed <- d.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("second Close blocked")
+	}
+}
+
+// BenchmarkHaarDetector measures the per-image latency with every CPU
+// detecting at once and reports the resident memory afterwards. The
+// resident memory includes OpenCV's allocations, which the Go heap
+// profile doesn't see, so it shouldn't grow with -benchtime.
+func BenchmarkHaarDetector(b *testing.B) {
+	var (
+		d   = newTestHaarDetector(b, runtime.NumCPU())
+		img = openTestImage(b)
+	)
+	defer d.Close()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			d.Detect(img)
+		}
+	})
+	b.StopTimer()
+	if rss, ok := residentMemory(); ok {
+		b.ReportMetric(float64(rss)/(1<<20), "rss-MB")
+	}
+}
+
+// residentMemory returns the resident set size of the process
+func residentMemory() (uint64, bool) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(data), &size, &resident); err != nil {
+		return 0, false
+	}
+	return resident * uint64(os.Getpagesize()), true
+}
@@ -0,0 +1,81 @@
+//go:build cgo
+// +build cgo
+
+package faceutil
+
+import (
+	"image"
+	"math"
+
+	"github.com/lazywei/go-opencv/opencv"
+)
+
+// InpaintMode removes the face by filling it in from the pixels around
+// it using OpenCV's inpainting. Radius is the neighbourhood used for
+// each pixel as a fraction of the face's width.
+type InpaintMode struct {
+	Radius float64
+}
+
+func NewInpaintMode() (ReplaceMode, error) {
+	return InpaintMode{Radius: 0.05}, nil
+}
+
+func (m InpaintMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	var (
+		rect = r.faceRegion(canvas, face)
+
+		// the surrounding pixels are copied in, so they're included
+		area = rect.Inset(-rect.Dx() / 4).Intersect(canvas.Rect)
+		w    = area.Dx()
+		h    = area.Dy()
+
+		src  = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 3)
+		mask = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 1)
+		dst  = opencv.CreateImage(w, h, opencv.IPL_DEPTH_8U, 3)
+
+		inside = func(x, y int) bool {
+			dx := (float64(x) + 0.5 - float64(rect.Min.X) - float64(rect.Dx())/2) / (float64(rect.Dx()) / 2)
+			dy := (float64(y) + 0.5 - float64(rect.Min.Y) - float64(rect.Dy())/2) / (float64(rect.Dy()) / 2)
+			return dx*dx+dy*dy <= 1
+		}
+	)
+	defer src.Release()
+	defer mask.Release()
+	defer dst.Release()
+
+	mask.Zero()
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			i := canvas.PixOffset(x, y)
+			src.Set2D(x-area.Min.X, y-area.Min.Y, opencv.NewScalar(
+				float64(canvas.Pix[i+2]),
+				float64(canvas.Pix[i+1]),
+				float64(canvas.Pix[i]),
+				0,
+			))
+			if inside(x, y) {
+				mask.Set2D(x-area.Min.X, y-area.Min.Y, opencv.ScalarAll(255))
+			}
+		}
+	}
+
+	radius := math.Max(m.Radius*float64(rect.Dx()), 3)
+	opencv.Inpaint(src, mask, dst, radius, opencv.CV_INPAINT_TELEA)
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if !inside(x, y) {
+				continue
+			}
+			var (
+				bgr = dst.Get2D(x-area.Min.X, y-area.Min.Y).Val()
+				i   = canvas.PixOffset(x, y)
+			)
+			canvas.Pix[i] = uint8(bgr[2])
+			canvas.Pix[i+1] = uint8(bgr[1])
+			canvas.Pix[i+2] = uint8(bgr[0])
+		}
+	}
+	return canvas
+}
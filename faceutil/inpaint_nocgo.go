@@ -0,0 +1,11 @@
+//go:build !cgo
+// +build !cgo
+
+package faceutil
+
+import "errors"
+
+// NewInpaintMode is unavailable without cgo
+func NewInpaintMode() (ReplaceMode, error) {
+	return nil, errors.New("inpainting requires cgo")
+}
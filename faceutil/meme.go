@@ -0,0 +1,250 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// TextStyle is how captions are drawn on photos
+type TextStyle int
+
+const (
+	// TextMeme is bold white capitals with a black outline
+	TextMeme TextStyle = iota
+
+	// TextSubtitle is white text on a translucent bar across the photo
+	TextSubtitle
+)
+
+func (s TextStyle) String() string {
+	switch s {
+	case TextMeme:
+		return "meme"
+	case TextSubtitle:
+		return "subtitle"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (s *TextStyle) Set(v string) error {
+	style, err := ParseTextStyle(v)
+	if err != nil {
+		return err
+	}
+	*s = style
+	return nil
+}
+
+func ParseTextStyle(s string) (TextStyle, error) {
+	switch s {
+	case "meme":
+		return TextMeme, nil
+	case "subtitle":
+		return TextSubtitle, nil
+	default:
+		return 0, fmt.Errorf("invalid text style: %s", s)
+	}
+}
+
+// TextPosition is the edge of the photo captions are drawn at
+type TextPosition int
+
+const (
+	TextAuto   TextPosition = iota // the edge which covers fewer faces
+	TextTop                        // the top edge
+	TextBottom                     // the bottom edge
+)
+
+func (p TextPosition) String() string {
+	switch p {
+	case TextAuto:
+		return "auto"
+	case TextTop:
+		return "top"
+	case TextBottom:
+		return "bottom"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (p *TextPosition) Set(v string) error {
+	pos, err := ParseTextPosition(v)
+	if err != nil {
+		return err
+	}
+	*p = pos
+	return nil
+}
+
+func ParseTextPosition(s string) (TextPosition, error) {
+	switch s {
+	case "auto":
+		return TextAuto, nil
+	case "top":
+		return TextTop, nil
+	case "bottom":
+		return TextBottom, nil
+	default:
+		return 0, fmt.Errorf("invalid text position: %s", s)
+	}
+}
+
+// TextOptions configure how captions are drawn on photos
+type TextOptions struct {
+	Style    TextStyle
+	Position TextPosition
+
+	// MaxLines is the most lines the caption is wrapped onto. The
+	// font shrinks until the caption fits. Zero is 2.
+	MaxLines int
+
+	// MinSize and MaxSize are the range of font sizes as a fraction
+	// of the photo's width. Zero is 0.04 and 0.1.
+	MinSize float64
+	MaxSize float64
+}
+
+// DrawText draws the caption on the image. The font is the largest which
+// fits the caption in the lines across the image. The text is placed as
+// close to the edge as it can be without covering the padded faces.
+func (r *Renderer) DrawText(img image.Image, faces []Detection, text string, opt TextOptions) *image.NRGBA {
+	canvas := imaging.Clone(img)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return canvas
+	}
+	var (
+		b        = canvas.Rect
+		w        = b.Dx()
+		margin   = maxInt(w/30, 1)
+		maxLines = opt.MaxLines
+		minSize  = opt.MinSize
+		maxSize  = opt.MaxSize
+	)
+	if maxLines <= 0 {
+		maxLines = 2
+	}
+	if minSize <= 0 {
+		minSize = 0.04
+	}
+	if maxSize <= 0 {
+		maxSize = 0.1
+	}
+	switch opt.Style {
+	case TextSubtitle:
+		var (
+			pad         = margin / 2
+			face, lines = fitText(regularFont, text, w-2*margin, maxLines, minSize*float64(w), maxSize*float64(w))
+			height      = len(lines)*lineHeight(face) + 2*pad
+			bar         = r.placeText(b, faces, image.Pt(w, height), margin, opt.Position)
+		)
+		draw.Draw(canvas, bar, image.NewUniform(color.NRGBA{0, 0, 0, 160}), image.ZP, draw.Over)
+		drawTextLines(canvas, face, lines, bar.Inset(pad), color.White)
+	default:
+		var (
+			face, lines = fitText(boldFont, strings.ToUpper(text), w-2*margin, maxLines, minSize*float64(w), maxSize*float64(w))
+			outline     = maxInt(face.Metrics().Height.Ceil()/16, 1)
+			size        = image.Pt(textWidth(face, lines)+2*outline, len(lines)*lineHeight(face)+2*outline)
+			block       = r.placeText(b, faces, size, margin, opt.Position)
+		)
+		drawOutlinedText(canvas, face, lines, block.Inset(outline), outline)
+	}
+	return canvas
+}
+
+// fitText returns the largest face between the sizes which wraps the text
+// onto at most maxLines lines no wider than width. The smallest size is
+// used when the text doesn't fit.
+func fitText(f *truetype.Font, text string, width, maxLines int, minSize, maxSize float64) (font.Face, []string) {
+	for size := maxSize; size > minSize; size *= 0.9 {
+		var (
+			face  = fontFace(f, size)
+			lines = wrapText(face, text, width)
+		)
+		if len(lines) <= maxLines && textWidth(face, lines) <= width {
+			return face, lines
+		}
+	}
+	face := fontFace(f, minSize)
+	return face, wrapText(face, text, width)
+}
+
+// textWidth is the width of the longest line
+func textWidth(face font.Face, lines []string) int {
+	var width int
+	for _, line := range lines {
+		width = maxInt(width, font.MeasureString(face, line).Ceil())
+	}
+	return width
+}
+
+// placeText returns where a block of text of the given size is drawn. The
+// positions are tried from the edge inwards and the first one which doesn't
+// cover any padded faces is used. When they all cover faces, the one which
+// covers the least is used.
+func (r *Renderer) placeText(b image.Rectangle, faces []Detection, size image.Point, margin int, pos TextPosition) image.Rectangle {
+	var (
+		x      = b.Min.X + (b.Dx()-size.X)/2
+		top    = b.Min.Y + margin
+		bottom = maxInt(b.Max.Y-margin-size.Y, top)
+		step   = maxInt(size.Y/4, 1)
+		keep   []image.Rectangle
+		ys     []int
+	)
+	for _, face := range faces {
+		keep = append(keep, addRectPadding(r.opt.Margin, face.Rect, b).Intersect(b))
+	}
+	for d := 0; top+d <= bottom; d += step {
+		switch pos {
+		case TextTop:
+			ys = append(ys, top+d)
+		case TextBottom:
+			ys = append(ys, bottom-d)
+		default:
+			ys = append(ys, bottom-d, top+d)
+		}
+	}
+	var (
+		best     image.Rectangle
+		bestArea = -1
+	)
+	for _, y := range ys {
+		var (
+			rect    = image.Rect(x, y, x+size.X, y+size.Y)
+			covered int
+		)
+		for _, k := range keep {
+			covered += area(rect.Intersect(k))
+		}
+		if covered == 0 {
+			return rect
+		}
+		if bestArea < 0 || covered < bestArea {
+			best, bestArea = rect, covered
+		}
+	}
+	return best
+}
+
+// drawOutlinedText draws the lines in white with a black outline
+func drawOutlinedText(canvas draw.Image, face font.Face, lines []string, r image.Rectangle, outline int) {
+	for dy := -outline; dy <= outline; dy++ {
+		for dx := -outline; dx <= outline; dx++ {
+			if dx*dx+dy*dy <= outline*outline {
+				drawTextLines(canvas, face, lines, r.Add(image.Pt(dx, dy)), color.Black)
+			}
+		}
+	}
+	drawTextLines(canvas, face, lines, r, color.White)
+}
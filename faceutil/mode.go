@@ -0,0 +1,343 @@
+package faceutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// ReplaceMode is how a detected face is replaced
+type ReplaceMode interface {
+	// Replace draws over the detected face. The selector and group are
+	// used by modes which draw faces from the face pack.
+	Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA
+}
+
+// trackMode is implemented by modes which make random choices. fix makes
+// the choices up front so a tracked face looks the same in every frame.
+type trackMode interface {
+	fix(r *Renderer, s *FaceSelector, group bool, pose Pose, width int) ReplaceMode
+}
+
+// OverlayMode draws a face from the face pack over the detected face
+type OverlayMode struct{}
+
+func (OverlayMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	return r.DrawFace(s, canvas, face, group)
+}
+
+func (OverlayMode) fix(r *Renderer, s *FaceSelector, group bool, pose Pose, width int) ReplaceMode {
+	return overlayFace{src: s.Select(group, pose, width)}
+}
+
+// overlayFace draws the face picked by OverlayMode
+type overlayFace struct {
+	src faceImage
+}
+
+func (m overlayFace) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	return r.drawFace(canvas, face, m.src)
+}
+
+// StackMode applies each of the modes in order. For example, an
+// OverlayMode followed by an AccessoryMode puts accessories on the
+// new face.
+type StackMode []ReplaceMode
+
+func (m StackMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	for _, mode := range m {
+		canvas = r.replaceFace(s, canvas, face, mode, group)
+	}
+	return canvas
+}
+
+func (m StackMode) fix(r *Renderer, s *FaceSelector, group bool, pose Pose, width int) ReplaceMode {
+	fixed := make(StackMode, len(m))
+	for i, mode := range m {
+		fixed[i] = fixMode(mode, r, s, group, pose, width)
+	}
+	return fixed
+}
+
+// fixMode makes the mode's random choices when it has any
+func fixMode(mode ReplaceMode, r *Renderer, s *FaceSelector, group bool, pose Pose, width int) ReplaceMode {
+	if m, ok := mode.(trackMode); ok {
+		return m.fix(r, s, group, pose, width)
+	}
+	return mode
+}
+
+// BlurMode blurs the face. Sigma is the blur radius as a fraction of the
+// face's width.
+type BlurMode struct {
+	Sigma float64
+}
+
+func (m BlurMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	rect := r.faceRegion(canvas, face)
+	blurred := imaging.Blur(imaging.Crop(canvas, rect), m.Sigma*float64(rect.Dx()))
+	return imaging.Overlay(canvas, featherMask(blurred, 0.2), rect.Min, 1)
+}
+
+// PixelateMode replaces the face with Blocks large pixels across
+type PixelateMode struct {
+	Blocks int
+}
+
+func (m PixelateMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	var (
+		rect   = r.faceRegion(canvas, face)
+		blocks = maxInt(m.Blocks, 1)
+		size   = maxInt(rect.Dx()/blocks, 1)
+		small  = imaging.Resize(imaging.Crop(canvas, rect), maxInt(rect.Dx()/size, 1), maxInt(rect.Dy()/size, 1), imaging.Box)
+		large  = imaging.Resize(small, rect.Dx(), rect.Dy(), imaging.NearestNeighbor)
+	)
+	return imaging.Overlay(canvas, featherMask(large, 0), rect.Min, 1)
+}
+
+// StickerMode covers the face with an image
+type StickerMode struct {
+	Image image.Image
+}
+
+func (m StickerMode) Replace(r *Renderer, s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	var (
+		rect    = r.faceRegion(canvas, face)
+		sticker = imaging.Fit(m.Image, rect.Dx(), rect.Dy(), imaging.Lanczos)
+	)
+	if face.Angle != 0 {
+		sticker = imaging.Rotate(sticker, face.Angle, color.Transparent)
+	}
+	return imaging.Overlay(canvas, sticker, getRectCenteredIn(sticker.Rect, rect).Min, 1)
+}
+
+// DefaultSticker is a smiley face used when no sticker image is given
+func DefaultSticker() *image.NRGBA {
+	const size = 256
+	var (
+		img    = image.NewNRGBA(image.Rect(0, 0, size, size))
+		c      = float64(size) / 2
+		yellow = color.NRGBA{255, 204, 51, 255}
+		brown  = color.NRGBA{102, 51, 0, 255}
+	)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var (
+				px = float64(x) + 0.5
+				py = float64(y) + 0.5
+				d  = math.Hypot(px-c, py-c)
+			)
+			switch {
+			case d > c:
+				continue
+			case d > c-8:
+				img.Set(x, y, brown)
+			case math.Hypot(px-c*0.68, py-c*0.72) < c*0.12,
+				math.Hypot(px-c*1.32, py-c*0.72) < c*0.12:
+				img.Set(x, y, brown)
+			case py > c && math.Abs(math.Hypot(px-c, py-c)-c*0.55) < c*0.06:
+				img.Set(x, y, brown)
+			default:
+				img.Set(x, y, yellow)
+			}
+		}
+	}
+	return img
+}
+
+// faceRegion is the padded face rect clipped to the canvas
+func (r *Renderer) faceRegion(canvas *image.NRGBA, face Detection) image.Rectangle {
+	return addRectPadding(r.opt.Margin, face.Rect, canvas.Rect).Intersect(canvas.Rect)
+}
+
+// ModeRule replaces up to Count faces with Mode. A Count of zero
+// replaces all the remaining faces.
+type ModeRule struct {
+	Mode  ReplaceMode
+	Count int
+}
+
+// ModeRules assign modes to faces from the largest face to the smallest.
+// Faces left over after the last rule aren't replaced.
+type ModeRules []ModeRule
+
+// Assign returns the mode for each face. The mode is nil for faces
+// which aren't replaced.
+func (rules ModeRules) Assign(faces []Detection) []ReplaceMode {
+	modes := make([]ReplaceMode, len(faces))
+	if len(rules) == 0 {
+		for i := range modes {
+			modes[i] = OverlayMode{}
+		}
+		return modes
+	}
+	order := make([]int, len(faces))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return faces[order[i]].Rect.Dx() > faces[order[j]].Rect.Dx()
+	})
+	for _, rule := range rules {
+		n := len(order)
+		if rule.Count > 0 && rule.Count < n {
+			n = rule.Count
+		}
+		for _, i := range order[:n] {
+			modes[i] = rule.Mode
+		}
+		order = order[n:]
+	}
+	return modes
+}
+
+// ModeOptions configure the modes created by ParseModeRules
+type ModeOptions struct {
+	BlurSigma      float64
+	Blocks         int
+	Sticker        image.Image
+	Accessories    *AccessoryPack
+	AccessoryCount int
+}
+
+// ParseModeRules parses a comma separated list of modes each with an
+// optional count. For example "overlay:1,blur" overlays the largest face
+// and blurs the rest. Modes joined with a + are stacked, so
+// "overlay+accessory" puts accessories on the new faces.
+func ParseModeRules(s string, opt ModeOptions) (ModeRules, error) {
+	var rules ModeRules
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		var (
+			name  = field
+			count int
+		)
+		if i := strings.Index(field, ":"); i >= 0 {
+			n, err := strconv.Atoi(field[i+1:])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid mode count: %s", field)
+			}
+			name, count = field[:i], n
+		}
+		mode, err := NewReplaceMode(name, opt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, ModeRule{Mode: mode, Count: count})
+	}
+	return rules, nil
+}
+
+// NewReplaceMode creates the named mode (overlay, blur, pixelate,
+// sticker, inpaint, or accessory). Names joined with a + create
+// a StackMode. The accessory mode can be created without a pack, but
+// NewRenderer rejects it until one is attached.
+func NewReplaceMode(name string, opt ModeOptions) (ReplaceMode, error) {
+	if names := strings.Split(name, "+"); len(names) > 1 {
+		var stack StackMode
+		for _, name := range names {
+			mode, err := NewReplaceMode(name, opt)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, mode)
+		}
+		return stack, nil
+	}
+	switch name {
+	case "overlay":
+		return OverlayMode{}, nil
+	case "blur":
+		return BlurMode{Sigma: opt.BlurSigma}, nil
+	case "pixelate":
+		return PixelateMode{Blocks: opt.Blocks}, nil
+	case "sticker", "emoji":
+		sticker := opt.Sticker
+		if sticker == nil {
+			sticker = DefaultSticker()
+		}
+		return StickerMode{Image: sticker}, nil
+	case "inpaint":
+		return NewInpaintMode()
+	case "accessory", "accessories":
+		return AccessoryMode{Pack: opt.Accessories, Count: opt.AccessoryCount}, nil
+	default:
+		return nil, fmt.Errorf("invalid replace mode: %s", name)
+	}
+}
+
+// UsesAccessories returns true when any of the rules use an AccessoryMode,
+// so the accessory pack only has to be loaded when it's needed
+func (rules ModeRules) UsesAccessories() bool {
+	return rules.anyMode(func(mode ReplaceMode) bool {
+		_, ok := mode.(AccessoryMode)
+		return ok
+	})
+}
+
+// missingAccessories returns true when an AccessoryMode doesn't have a pack
+func (rules ModeRules) missingAccessories() bool {
+	return rules.anyMode(func(mode ReplaceMode) bool {
+		m, ok := mode.(AccessoryMode)
+		return ok && m.Pack == nil
+	})
+}
+
+// anyMode returns true when f is true for any of the modes, including
+// the ones inside a StackMode
+func (rules ModeRules) anyMode(f func(ReplaceMode) bool) bool {
+	for _, rule := range rules {
+		if anyMode(rule.Mode, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyMode(mode ReplaceMode, f func(ReplaceMode) bool) bool {
+	if stack, ok := mode.(StackMode); ok {
+		for _, m := range stack {
+			if anyMode(m, f) {
+				return true
+			}
+		}
+		return false
+	}
+	return f(mode)
+}
+
+// usesFaces returns true when any of the rules draw from the face pack
+func (rules ModeRules) usesFaces() bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		if usesFaces(rule.Mode) {
+			return true
+		}
+	}
+	return false
+}
+
+func usesFaces(mode ReplaceMode) bool {
+	switch mode := mode.(type) {
+	case OverlayMode:
+		return true
+	case StackMode:
+		for _, m := range mode {
+			if usesFaces(m) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package faceutil
+
+import (
+	"image"
+	"sort"
+)
+
+// SuppressOverlaps performs non-maximum suppression. Detections are visited
+// from highest to lowest score and dropped when their intersection over
+// union with an already kept detection exceeds the threshold.
+func SuppressOverlaps(faces []Detection, threshold float64) []Detection {
+	sorted := make([]Detection, len(faces))
+	copy(sorted, faces)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Score > sorted[j].Score
+	})
+	var kept []Detection
+	for _, face := range sorted {
+		overlaps := false
+		for _, k := range kept {
+			if IntersectionOverUnion(face.Rect, k.Rect) > threshold {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, face)
+		}
+	}
+	return kept
+}
+
+// IntersectionOverUnion returns the ratio of the overlapping area
+// of the rectangles to their combined area.
+func IntersectionOverUnion(a, b image.Rectangle) float64 {
+	var (
+		inter = area(a.Intersect(b))
+		union = area(a) + area(b) - inter
+	)
+	if union == 0 {
+		return 0
+	}
+	return float64(inter) / float64(union)
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
@@ -0,0 +1,183 @@
+package faceutil
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// manifestName is the name of the manifest in a face pack
+const manifestName = "manifest.json"
+
+// packManifest describes the faces in a face pack.
+//
+//	{
+//	  "name": "nick",
+//	  "faces": [
+//	    {
+//	      "file": "faces/smile.png",
+//	      "tags": ["smile"],
+//	      "weight": 2,
+//	      "pose": "frontal",
+//	      "eyes": {"left": [180, 430], "right": [420, 410]},
+//	      "min_size": 40,
+//	      "max_size": 400,
+//	      "group": "allow"
+//	    }
+//	  ]
+//	}
+//
+// Only the file is required. The weight defaults to 1, the pose to frontal
+// and group to allow.
+type packManifest struct {
+	Name  string         `json:"name"`
+	Faces []manifestFace `json:"faces"`
+}
+
+type manifestFace struct {
+	File    string        `json:"file"`
+	Tags    []string      `json:"tags,omitempty"`
+	Weight  *float64      `json:"weight,omitempty"`
+	Pose    string        `json:"pose,omitempty"`
+	Eyes    *manifestEyes `json:"eyes,omitempty"`
+	MinSize int           `json:"min_size,omitempty"`
+	MaxSize int           `json:"max_size,omitempty"`
+	Group   string        `json:"group,omitempty"`
+}
+
+type manifestEyes struct {
+	Left  [2]int `json:"left"`
+	Right [2]int `json:"right"`
+}
+
+// openFunc opens a file inside a face pack
+type openFunc func(name string) (io.ReadCloser, error)
+
+func loadDirPack(dir string) (*FacePack, error) {
+	return loadManifestPack(dir, openDir(dir))
+}
+
+func loadZipPack(file string) (*FacePack, error) {
+	r, open, err := openZip(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return loadManifestPack(file, open)
+}
+
+// openZip opens a zip archive and returns a function which opens the
+// files inside it. The archive must be closed when done.
+func openZip(file string) (io.Closer, openFunc, error) {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[path.Clean(f.Name)] = f
+	}
+	return r, func(name string) (io.ReadCloser, error) {
+		f, ok := files[path.Clean(name)]
+		if !ok {
+			return nil, fmt.Errorf("%s: file not found", name)
+		}
+		return f.Open()
+	}, nil
+}
+
+// openDir returns a function which opens the files inside the directory
+func openDir(dir string) openFunc {
+	return func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+}
+
+// loadManifestPack reads the manifest and the face images it lists
+func loadManifestPack(source string, open openFunc) (*FacePack, error) {
+	var m packManifest
+	if err := decodeJSON(open, manifestName, &m); err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+	pack := &FacePack{Name: m.Name}
+	for _, mf := range m.Faces {
+		face, err := loadManifestFace(open, mf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", source, mf.File, err)
+		}
+		pack.faces = append(pack.faces, face)
+	}
+	return pack, nil
+}
+
+func loadManifestFace(open openFunc, mf manifestFace) (faceImage, error) {
+	face := faceImage{
+		name:    mf.File,
+		tags:    mf.Tags,
+		weight:  1,
+		minSize: mf.MinSize,
+		maxSize: mf.MaxSize,
+	}
+	if mf.File == "" {
+		return face, errors.New("missing file")
+	}
+	if mf.Weight != nil {
+		if *mf.Weight < 0 {
+			return face, fmt.Errorf("invalid weight: %g", *mf.Weight)
+		}
+		face.weight = *mf.Weight
+	}
+	var err error
+	if face.pose, err = ParsePose(mf.Pose); err != nil {
+		return face, err
+	}
+	if face.group, err = parseGroupUse(mf.Group); err != nil {
+		return face, err
+	}
+	if mf.Eyes != nil {
+		face.eyes = &Eyes{
+			Left:  image.Pt(mf.Eyes.Left[0], mf.Eyes.Left[1]),
+			Right: image.Pt(mf.Eyes.Right[0], mf.Eyes.Right[1]),
+		}
+	}
+	r, err := open(mf.File)
+	if err != nil {
+		return face, err
+	}
+	defer r.Close()
+	face.img, err = DecodeImage(r)
+	return face, err
+}
+
+func decodeJSON(open openFunc, name string, v interface{}) error {
+	r, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
+
+func parseGroupUse(s string) (GroupUse, error) {
+	switch s {
+	case "", "allow":
+		return GroupAllow, nil
+	case "only":
+		return GroupOnly, nil
+	case "never":
+		return GroupNever, nil
+	default:
+		return 0, fmt.Errorf("invalid group: %s", s)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,212 @@
+package faceutil
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Selection is which of the detected faces are replaced
+type Selection int
+
+const (
+	SelectAll       Selection = iota // every face
+	SelectLargest                    // the Count largest faces
+	SelectRandom                     // Count random faces
+	SelectAllButOne                  // all but one random face
+)
+
+func (s Selection) String() string {
+	switch s {
+	case SelectAll:
+		return "all"
+	case SelectLargest:
+		return "largest"
+	case SelectRandom:
+		return "random"
+	case SelectAllButOne:
+		return "allbutone"
+	default:
+		return "invalid"
+	}
+}
+
+// Policy decides which detected faces are replaced and which face
+// pack tier each one is drawn from. The zero value replaces every face.
+type Policy struct {
+	Select Selection
+
+	// Count is the number of faces replaced by SelectLargest and
+	// SelectRandom. With SelectRandom, zero gives each face even odds.
+	Count int
+
+	// MinSize skips faces narrower than this many pixels
+	MinSize int
+
+	// GroupSize is the number of faces which makes a photo a group
+	// photo. Group photos are drawn from the group tier. Zero uses 4.
+	GroupSize int
+
+	// SmallSize draws faces narrower than this many pixels from the
+	// group tier even when the photo isn't a group photo. Zero is
+	// disabled.
+	SmallSize int
+}
+
+func (p Policy) String() string {
+	var fields []string
+	switch p.Select {
+	case SelectLargest:
+		fields = append(fields, fmt.Sprintf("largest:%d", p.Count))
+	case SelectRandom:
+		if p.Count > 0 {
+			fields = append(fields, fmt.Sprintf("random:%d", p.Count))
+		} else {
+			fields = append(fields, "random")
+		}
+	default:
+		fields = append(fields, p.Select.String())
+	}
+	if p.MinSize > 0 {
+		fields = append(fields, fmt.Sprintf("min:%d", p.MinSize))
+	}
+	if p.GroupSize > 0 {
+		fields = append(fields, fmt.Sprintf("group:%d", p.GroupSize))
+	}
+	if p.SmallSize > 0 {
+		fields = append(fields, fmt.Sprintf("small:%d", p.SmallSize))
+	}
+	return strings.Join(fields, ",")
+}
+
+// Set implements flag.Value
+func (p *Policy) Set(s string) error {
+	policy, err := ParsePolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// ParsePolicy parses a comma separated policy. For example
+// "largest:2,min:40" replaces the 2 largest faces which are at
+// least 40 pixels wide. The fields are:
+//
+//	all, largest:N, random, random:N, allbutone
+//	min:PX    skip faces narrower than PX
+//	group:N   photos with N faces are group photos
+//	small:PX  faces narrower than PX use the group tier
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		var (
+			name  = field
+			value string
+			n     int
+		)
+		if i := strings.Index(field, ":"); i >= 0 {
+			name, value = field[:i], field[i+1:]
+			var err error
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				return Policy{}, fmt.Errorf("invalid policy value: %s", field)
+			}
+		}
+		needsValue := func() error {
+			if value == "" {
+				return fmt.Errorf("missing policy value: %s", field)
+			}
+			return nil
+		}
+		switch name {
+		case "all":
+			p.Select = SelectAll
+		case "allbutone":
+			p.Select = SelectAllButOne
+		case "largest":
+			if err := needsValue(); err != nil {
+				return Policy{}, err
+			}
+			p.Select, p.Count = SelectLargest, n
+		case "random":
+			p.Select, p.Count = SelectRandom, n
+		case "min":
+			if err := needsValue(); err != nil {
+				return Policy{}, err
+			}
+			p.MinSize = n
+		case "group":
+			if err := needsValue(); err != nil {
+				return Policy{}, err
+			}
+			p.GroupSize = n
+		case "small":
+			if err := needsValue(); err != nil {
+				return Policy{}, err
+			}
+			p.SmallSize = n
+		default:
+			return Policy{}, fmt.Errorf("invalid policy: %s", field)
+		}
+	}
+	return p, nil
+}
+
+// apply returns which faces are replaced and which ones are drawn from
+// the group tier. The count is the number of people in the photo.
+func (p Policy) apply(faces []Detection, count int, rnd *rand.Rand) (replace, group []bool) {
+	replace = make([]bool, len(faces))
+	group = make([]bool, len(faces))
+
+	groupSize := p.GroupSize
+	if groupSize <= 0 {
+		groupSize = defaultGroupSize
+	}
+	var candidates []int
+	for i, face := range faces {
+		width := face.Rect.Dx()
+		group[i] = count >= groupSize || width < p.SmallSize
+		if width >= p.MinSize {
+			candidates = append(candidates, i)
+		}
+	}
+
+	switch p.Select {
+	case SelectLargest:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return faces[candidates[i]].Rect.Dx() > faces[candidates[j]].Rect.Dx()
+		})
+		candidates = candidates[:minInt(p.Count, len(candidates))]
+	case SelectRandom:
+		if p.Count > 0 {
+			var picked []int
+			for _, i := range rnd.Perm(len(candidates))[:minInt(p.Count, len(candidates))] {
+				picked = append(picked, candidates[i])
+			}
+			candidates = picked
+		} else {
+			var picked []int
+			for _, i := range candidates {
+				if rnd.Intn(2) == 0 {
+					picked = append(picked, i)
+				}
+			}
+			candidates = picked
+		}
+	case SelectAllButOne:
+		if len(candidates) > 0 {
+			keep := rnd.Intn(len(candidates))
+			candidates = append(candidates[:keep], candidates[keep+1:]...)
+		}
+	}
+	for _, i := range candidates {
+		replace[i] = true
+	}
+	return replace, group
+}
@@ -1,87 +1,297 @@
 package faceutil
 
 import (
-	"flag"
+	"errors"
 	"image"
 	"image/color"
+	"math/rand"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/disintegration/imaging"
-	"github.com/lazywei/go-opencv/opencv"
 )
 
-var (
-	minNeighboor = flag.Int("min.neighboor", 9, "the lower this number is, the more faces will be found")
-	haarCascade  = flag.String("haar", "haarcascade_frontalface_alt.xml", "The location of the Haar Cascade XML configuration to be provided to OpenCV.")
-	margin       = flag.Float64("margin", 60.0, "The face rectangle margin")
-	faceOpacity  = flag.Float64("face.opacity", 1.0, "Face opacity [0-255]")
+// RenderOptions configure a Renderer
+type RenderOptions struct {
+	// Detector finds the faces in the photos
+	Detector Detector
 
-	shouldDrawFace  = flag.Bool("draw.face", true, "Draw the face")
-	shouldDrawRects = flag.Bool("draw.rects", false, "Show the detection rectangles")
-)
+	// Faces are drawn over the detected faces
+	Faces *FacePack
+
+	// Eyes finds the eyes inside the detected faces so the faces
+	// can be lined up with them. The faces aren't aligned when
+	// it's nil.
+	Eyes Detector
+
+	// Overlap is the maximum intersection over union between
+	// detected faces
+	Overlap float64
+
+	// Filter rejects detections which probably aren't faces.
+	// Nothing is rejected when it's nil.
+	Filter *Filter
+
+	// Margin is the padding added around the detected face as
+	// a percentage of its size
+	Margin float64
+
+	// Opacity is the opacity of the drawn faces [0-1]
+	Opacity float64
+
+	// ColorMatch is how much to match the face's lighting and
+	// skin tone to the photo [0-1]
+	ColorMatch float64
+
+	Blend BlendOptions
+
+	// Modes are how the faces are replaced. All the faces are
+	// overlaid with faces from the face pack when it's empty.
+	Modes ModeRules
+
+	// Policy decides which faces are replaced and which face
+	// pack tier they're drawn from
+	Policy Policy
+
+	// DrawFace draws the faces and DrawRects draws the detection
+	// rectangles for debugging
+	DrawFace  bool
+	DrawRects bool
+
+	// Rand is the random source used to pick and flip the faces.
+	// A source seeded with the current time is used when it's nil.
+	Rand rand.Source
+}
+
+// Renderer detects the faces in photos and draws the face pack over them
+type Renderer struct {
+	opt  RenderOptions
+	rand *rand.Rand
+}
+
+func NewRenderer(opt RenderOptions) (*Renderer, error) {
+	if opt.Detector == nil {
+		return nil, errors.New("missing detector")
+	}
+	if opt.Modes.usesFaces() && (opt.Faces == nil || len(opt.Faces.faces) == 0) {
+		return nil, errors.New("missing faces")
+	}
+	if opt.Modes.missingAccessories() {
+		return nil, errors.New("accessory mode requires an accessory pack")
+	}
+	src := opt.Rand
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &Renderer{
+		opt:  opt,
+		rand: rand.New(&lockedSource{src: src}),
+	}, nil
+}
+
+// WithPolicy returns a copy of the renderer which uses the policy.
+// The copy shares the renderer's random source.
+func (r *Renderer) WithPolicy(p Policy) *Renderer {
+	c := *r
+	c.opt.Policy = p
+	return &c
+}
+
+// Detect finds the faces in the image and applies the filter.
+// It returns the faces which passed and the ones which were rejected.
+func (r *Renderer) Detect(i image.Image) ([]Detection, []Rejection) {
+	faces := SuppressOverlaps(r.opt.Detector.Detect(i), r.opt.Overlap)
+	var rejected []Rejection
+	if r.opt.Filter != nil {
+		faces, rejected = r.opt.Filter.Apply(i, faces)
+		logRejections(rejected)
+	}
+	sort.Sort(ByCenterY(faces))
+	return faces, rejected
+}
+
+// NewSelector creates a selector which picks the faces for a single photo.
+// See FaceSelector for how the recent faces are used.
+func (r *Renderer) NewSelector(recent [][]string) *FaceSelector {
+	return newFaceSelector(r.opt.Faces, r.rand, recent)
+}
+
+// DrawFace draws a face picked by the selector over the detected face.
+// When group is true, the face is picked from the ones which can be used
+// in group photos.
+func (r *Renderer) DrawFace(s *FaceSelector, canvas *image.NRGBA, face Detection, group bool) *image.NRGBA {
+	// select a random source face looking the same way
+	srcFace := s.Select(group, face.Pose, face.Rect.Dx())
+	return r.drawFace(canvas, face, srcFace)
+}
 
-func DrawFace(canvas *image.NRGBA, faceRect image.Rectangle, primary bool) *image.NRGBA {
+// drawFace draws the source face over the detected face
+func (r *Renderer) drawFace(canvas *image.NRGBA, face Detection, srcFace faceImage) *image.NRGBA {
 	var (
 		// rect colors
-		red   = color.RGBA{255, 0, 0, 255}
-		green = color.RGBA{0, 255, 0, 255}
-		blue  = color.RGBA{0, 0, 255, 255}
+		red    = color.RGBA{255, 0, 0, 255}
+		green  = color.RGBA{0, 255, 0, 255}
+		blue   = color.RGBA{0, 0, 255, 255}
+		yellow = color.RGBA{255, 255, 0, 255}
 
-		// select a random source face
-		srcFaceImg = randomFace(primary)
+		faceRect = face.Rect
 
 		// add padding around detected face rect
-		paddedRect = addRectPadding(*margin, faceRect, canvas.Bounds())
+		paddedRect = addRectPadding(r.opt.Margin, faceRect, canvas.Bounds())
 
+		faceImg       *image.NRGBA
+		placementRect image.Rectangle
+		eyes          Eyes
+		aligned       bool
+	)
+
+	// line the face's eyes up with the detected eyes when possible
+	if srcFace.eyes != nil {
+		eyes, aligned = locateEyes(r.opt.Eyes, canvas, face)
+	}
+
+	if aligned {
+		faceImg, placementRect = alignFace(srcFace, eyes)
+	} else {
 		// resize the face image to fit inside the padded rect
-		faceImg = imaging.Resize(srcFaceImg, paddedRect.Dx(), 0, imaging.Lanczos)
+		faceImg = imaging.Resize(srcFace.img, paddedRect.Dx(), 0, imaging.Lanczos)
 
 		// center the face rect size inside the padded rect
 		placementRect = getRectCenteredIn(faceImg.Rect, paddedRect)
-	)
 
-	if *shouldDrawFace {
-		canvas = imaging.Overlay(canvas, faceImg, placementRect.Min, *faceOpacity)
+		// tilt the face to match the detected face. The padding isn't
+		// symmetric, so the placement center is rotated too.
+		if face.Angle != 0 {
+			faceImg = imaging.Rotate(faceImg, face.Angle, color.Transparent)
+			center := rotatePoint(getRectCenter(paddedRect), getRectCenter(faceRect), face.Angle)
+			placementRect = getRectCenteredAt(faceImg.Rect, center)
+		}
+	}
+
+	// match the lighting and skin tone of the photo
+	TransferColor(faceImg, canvas, faceRect, r.opt.ColorMatch)
+
+	if r.opt.DrawFace {
+		canvas = Blend(canvas, faceImg, placementRect.Min, r.opt.Blend, r.opt.Opacity)
 	}
 
-	if *shouldDrawRects {
-		drawRect(canvas, faceRect, red)
-		drawRect(canvas, paddedRect, green)
+	if r.opt.DrawRects {
+		drawPolygon(canvas, face.RotatedCorners(), red)
+		if aligned {
+			drawLine(canvas, eyes.Left, eyes.Right, yellow)
+		} else {
+			drawRect(canvas, paddedRect, green)
+		}
 		drawRect(canvas, placementRect, blue)
 	}
 
 	return canvas
 }
 
-func DrawFaces(base image.Image, rects []image.Rectangle) *image.NRGBA {
+// faceModes applies the policy to the faces and assigns the modes to the
+// ones which are replaced. The count is the number of people in the photo.
+// The mode is nil for the faces which are kept.
+func (r *Renderer) faceModes(faces []Detection, count int) ([]ReplaceMode, []bool) {
 	var (
-		canvas     = canvasFromImage(base)
-		usePrimary = len(rects) < 4
+		replace, group = r.opt.Policy.apply(faces, count, r.rand)
+		modes          = make([]ReplaceMode, len(faces))
+		index          []int
+		replaced       []Detection
 	)
-	for _, faceRect := range rects {
-		canvas = DrawFace(canvas, faceRect, usePrimary)
+	for i, face := range faces {
+		if replace[i] {
+			index = append(index, i)
+			replaced = append(replaced, face)
+		}
+	}
+	for i, mode := range r.opt.Modes.Assign(replaced) {
+		modes[index[i]] = mode
+	}
+	return modes, group
+}
+
+// replaceFace replaces the detected face using the mode. A nil mode keeps
+// the face, which is outlined in cyan when DrawRects is set.
+func (r *Renderer) replaceFace(s *FaceSelector, canvas *image.NRGBA, face Detection, mode ReplaceMode, group bool) *image.NRGBA {
+	switch mode.(type) {
+	case nil:
+		if r.opt.DrawRects {
+			cyan := color.RGBA{0, 255, 255, 255}
+			drawPolygon(canvas, face.RotatedCorners(), cyan)
+			drawLabel(canvas, "kept", image.Pt(face.Rect.Min.X, face.Rect.Min.Y-4), cyan)
+		}
+		return canvas
+	case OverlayMode, overlayFace, StackMode:
+		// drawFace handles the debug options itself and the stacked
+		// modes are replaced one at a time
+		return mode.Replace(r, s, canvas, face, group)
+	}
+	if r.opt.DrawFace {
+		canvas = mode.Replace(r, s, canvas, face, group)
+	}
+	if r.opt.DrawRects {
+		drawPolygon(canvas, face.RotatedCorners(), color.RGBA{255, 0, 0, 255})
 	}
 	return canvas
 }
 
-func DetectFaces(i image.Image) []image.Rectangle {
+// Draw draws faces over the detected faces
+func (r *Renderer) Draw(base image.Image, faces []Detection) *image.NRGBA {
+	return r.DrawWith(r.NewSelector(nil), base, faces)
+}
+
+// DrawWith draws the faces picked by the selector over the detected faces
+func (r *Renderer) DrawWith(s *FaceSelector, base image.Image, faces []Detection) *image.NRGBA {
 	var (
-		output  []image.Rectangle
-		cascade = opencv.LoadHaarClassifierCascade(*haarCascade)
-		faces   = cascade.DetectObjects(opencv.FromImage(i), *minNeighboor)
+		canvas       = canvasFromImage(base)
+		modes, group = r.faceModes(faces, len(faces))
 	)
-	defer cascade.Release()
-	for _, face := range faces {
-		output = append(output, image.Rectangle{
-			Min: image.Point{face.X(), face.Y()},
-			Max: image.Point{face.X() + face.Width(), face.Y() + face.Height()},
-		})
+	for i, face := range faces {
+		canvas = r.replaceFace(s, canvas, face, modes[i], group[i])
 	}
-	sort.Sort(ByCenterY(output))
-	return output
+	return canvas
+}
+
+// Replace detects the faces in the image and draws faces over them
+func (r *Renderer) Replace(i image.Image) *image.NRGBA {
+	return r.ReplaceWith(r.NewSelector(nil), i)
+}
+
+// ReplaceWith replaces the faces using the faces picked by the selector
+func (r *Renderer) ReplaceWith(s *FaceSelector, i image.Image) *image.NRGBA {
+	faces, rejected := r.Detect(i)
+	return r.DrawRejections(r.DrawWith(s, i, faces), rejected)
+}
+
+// DrawRejections outlines the rejected detections and labels them with
+// the reason they were dropped. It only draws when DrawRects is set.
+func (r *Renderer) DrawRejections(canvas *image.NRGBA, rejected []Rejection) *image.NRGBA {
+	if !r.opt.DrawRects {
+		return canvas
+	}
+	magenta := color.RGBA{255, 0, 255, 255}
+	for _, rej := range rejected {
+		drawPolygon(canvas, rej.RotatedCorners(), magenta)
+		drawLabel(canvas, rej.Reason, image.Pt(rej.Rect.Min.X, rej.Rect.Min.Y-4), magenta)
+	}
+	return canvas
+}
+
+// lockedSource makes a random source safe for concurrent use
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
 }
 
-func ReplaceFaces(i image.Image) *image.NRGBA {
-	faces := DetectFaces(i)
-	return DrawFaces(i, faces)
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
 }
@@ -0,0 +1,131 @@
+package faceutil
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// RotatingDetector finds tilted faces by also running a detector on rotated
+// copies of the image. The detections are mapped back to the original image
+// and their Angle is set to the tilt of the face.
+type RotatingDetector struct {
+	detector  Detector
+	angles    []float64
+	threshold float64
+}
+
+func NewRotatingDetector(d Detector, angles []float64, threshold float64) *RotatingDetector {
+	return &RotatingDetector{
+		detector:  d,
+		angles:    angles,
+		threshold: threshold,
+	}
+}
+
+func (d *RotatingDetector) Detect(i image.Image) []Detection {
+	return d.detectResized(i, 1)
+}
+
+func (d *RotatingDetector) detectResized(i image.Image, scale float64) []Detection {
+	var (
+		faces  = detectResized(d.detector, i, scale)
+		bounds = i.Bounds()
+		center = rectCenterF(bounds)
+	)
+	for _, angle := range d.angles {
+		if angle == 0 {
+			continue
+		}
+		var (
+			rotated       = imaging.Rotate(i, angle, color.Transparent)
+			rotatedCenter = rectCenterF(rotated.Bounds())
+		)
+		for _, face := range detectResized(d.detector, rotated, scale) {
+			// the face is upright in the rotated image, so in the original
+			// it's tilted the other way.
+			var (
+				c    = rectCenterF(face.Rect)
+				x, y = rotateF(c.x-rotatedCenter.x, c.y-rotatedCenter.y, -angle)
+				cx   = round(center.x + x)
+				cy   = round(center.y + y)
+				w    = face.Rect.Dx()
+				h    = face.Rect.Dy()
+			)
+			face.Rect = image.Rect(cx-w/2, cy-h/2, cx-w/2+w, cy-h/2+h)
+			face.Angle = normalizeAngle(face.Angle - angle)
+			faces = append(faces, face)
+		}
+	}
+	return SuppressOverlaps(faces, d.threshold)
+}
+
+// Close closes the wrapped detector
+func (d *RotatingDetector) Close() error {
+	if c, ok := d.detector.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// RotatedCorners returns the corners of the detection's rectangle after it
+// has been rotated around its center by the detection's angle.
+func (d Detection) RotatedCorners() [4]image.Point {
+	var (
+		r       = d.Rect
+		c       = getRectCenter(r)
+		corners = [4]image.Point{
+			r.Min,
+			{r.Max.X, r.Min.Y},
+			r.Max,
+			{r.Min.X, r.Max.Y},
+		}
+	)
+	for i, p := range corners {
+		corners[i] = rotatePoint(p, c, d.Angle)
+	}
+	return corners
+}
+
+type pointF struct {
+	x, y float64
+}
+
+func rectCenterF(r image.Rectangle) pointF {
+	return pointF{
+		x: float64(r.Min.X+r.Max.X) / 2,
+		y: float64(r.Min.Y+r.Max.Y) / 2,
+	}
+}
+
+// rotateF rotates the vector counter-clockwise, as displayed,
+// by angle degrees.
+func rotateF(x, y, angle float64) (float64, float64) {
+	sin, cos := math.Sincos(math.Pi * angle / 180)
+	return x*cos + y*sin, -x*sin + y*cos
+}
+
+// rotatePoint rotates p around c counter-clockwise, as displayed,
+// by angle degrees.
+func rotatePoint(p, c image.Point, angle float64) image.Point {
+	x, y := rotateF(float64(p.X-c.X), float64(p.Y-c.Y), angle)
+	return image.Point{
+		X: c.X + round(x),
+		Y: c.Y + round(y),
+	}
+}
+
+// normalizeAngle maps the angle into the range (-180, 180]
+func normalizeAngle(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	switch {
+	case angle > 180:
+		angle -= 360
+	case angle <= -180:
+		angle += 360
+	}
+	return angle
+}
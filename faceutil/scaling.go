@@ -0,0 +1,119 @@
+package faceutil
+
+import (
+	"image"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ScalingDetector runs a detector on a downscaled working copy of the image
+// so that large photos are faster to process. When upscale is greater than
+// one, small faces are also looked for in overlapping crops which are scaled
+// up by that much relative to the working copy. The detections are mapped
+// back to the full resolution image.
+type ScalingDetector struct {
+	detector  Detector
+	size      int
+	upscale   float64
+	threshold float64
+}
+
+// NewScalingDetector creates a ScalingDetector. The working copy's longest
+// side is at most size pixels. A size of zero keeps the full resolution.
+func NewScalingDetector(d Detector, size int, upscale, threshold float64) *ScalingDetector {
+	return &ScalingDetector{
+		detector:  d,
+		size:      size,
+		upscale:   upscale,
+		threshold: threshold,
+	}
+}
+
+func (d *ScalingDetector) Detect(i image.Image) []Detection {
+	var (
+		bounds  = i.Bounds()
+		longest = maxInt(bounds.Dx(), bounds.Dy())
+		scale   = 1.0
+	)
+	if longest == 0 {
+		return nil
+	}
+	if d.size > 0 && longest > d.size {
+		scale = float64(d.size) / float64(longest)
+	}
+	faces := d.detectScaled(i, bounds, scale)
+	if d.upscale > 1 {
+		// the crops are sized so they're about as big as the working
+		// copy once they're scaled up. They overlap by half, so any
+		// face up to half a crop wide is entirely inside one of them.
+		var (
+			size = int(math.Ceil(float64(longest) / d.upscale))
+			step = maxInt(size/2, 1)
+		)
+		for _, y := range cropStarts(bounds.Min.Y, bounds.Max.Y, size, step) {
+			for _, x := range cropStarts(bounds.Min.X, bounds.Max.X, size, step) {
+				crop := image.Rect(x, y, x+size, y+size).Intersect(bounds)
+				for _, face := range d.detectScaled(i, crop, scale*d.upscale) {
+					if face.Rect.Dx() <= size/2 {
+						faces = append(faces, face)
+					}
+				}
+			}
+		}
+	}
+	return SuppressOverlaps(faces, d.threshold)
+}
+
+// detectScaled runs the detector on the part of the image inside r after
+// resizing it by scale and maps the detections back onto the image. The
+// detector's face size limits are scaled too, so they stay in the full
+// resolution image's pixels.
+func (d *ScalingDetector) detectScaled(i image.Image, r image.Rectangle, scale float64) []Detection {
+	if scale == 1 && r == i.Bounds() {
+		return d.detector.Detect(i)
+	}
+	var (
+		w       = maxInt(round(float64(r.Dx())*scale), 1)
+		h       = maxInt(round(float64(r.Dy())*scale), 1)
+		working = imaging.Resize(imaging.Crop(i, r), w, h, imaging.Linear)
+		sx      = float64(r.Dx()) / float64(w)
+		sy      = float64(r.Dy()) / float64(h)
+		faces   = detectResized(d.detector, working, scale)
+	)
+	for k, face := range faces {
+		faces[k].Rect = image.Rect(
+			r.Min.X+round(float64(face.Rect.Min.X)*sx),
+			r.Min.Y+round(float64(face.Rect.Min.Y)*sy),
+			r.Min.X+round(float64(face.Rect.Max.X)*sx),
+			r.Min.Y+round(float64(face.Rect.Max.Y)*sy),
+		)
+	}
+	return faces
+}
+
+// cropStarts returns where the crops along one axis start so that they
+// cover min to max. The last crop is moved back to end at max.
+func cropStarts(min, max, size, step int) []int {
+	if max-min <= size {
+		return []int{min}
+	}
+	var starts []int
+	for start := min; ; start += step {
+		if start+size >= max {
+			starts = append(starts, max-size)
+			break
+		}
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// Close closes the wrapped detector
+func (d *ScalingDetector) Close() error {
+	if c, ok := d.detector.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package faceutil
+
+import "math/rand"
+
+// FaceSelector picks the faces drawn on a single photo. A face isn't used
+// twice in the same photo while there are other suitable faces, and faces
+// used in recent posts are less likely to be picked.
+type FaceSelector struct {
+	pack   *FacePack
+	rand   *rand.Rand
+	used   []string
+	recent map[string]int
+	window int
+}
+
+// newFaceSelector creates a selector for the face pack. The recent faces
+// are the faces used in each of the last posts, starting with the most
+// recent one. A face used in the most recent post has its weight divided
+// by the number of recent posts plus one and the penalty shrinks with
+// each post after that.
+func newFaceSelector(pack *FacePack, rnd *rand.Rand, recent [][]string) *FaceSelector {
+	s := &FaceSelector{
+		pack:   pack,
+		rand:   rnd,
+		recent: map[string]int{},
+		window: len(recent),
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		for _, name := range recent[i] {
+			s.recent[name] = i
+		}
+	}
+	return s
+}
+
+// Used returns the names of the faces picked so far
+func (s *FaceSelector) Used() []string {
+	return s.used
+}
+
+// Select picks a face for a detected face of the given width and pose.
+// The face is picked from the ones which can be used in the photo, look
+// in the direction of the pose, haven't been used in the photo yet, and
+// prefer the size. Each requirement is dropped when no faces meet it.
+// Profile faces looking the other way are mirrored to match.
+func (s *FaceSelector) Select(group bool, pose Pose, width int) faceImage {
+	var (
+		faces   = s.pack.faces
+		matches []faceImage
+	)
+	for _, filter := range []func(f *faceImage) bool{
+		func(f *faceImage) bool { return f.usable(group) },
+		func(f *faceImage) bool { return f.pose == pose || f.pose == pose.Mirror() },
+		func(f *faceImage) bool { return !s.isUsed(f.name) },
+		func(f *faceImage) bool { return f.fits(width) },
+	} {
+		matches = nil
+		for i := range faces {
+			if filter(&faces[i]) {
+				matches = append(matches, faces[i])
+			}
+		}
+		if len(matches) > 0 {
+			faces = matches
+		}
+	}
+	face := faces[s.weightedChoice(faces)]
+	s.used = append(s.used, face.name)
+	switch {
+	case face.pose != PoseFrontal && face.pose != pose:
+		return face.flip()
+	case face.pose == PoseFrontal && s.rand.Intn(2) == 0:
+		return face.flip()
+	default:
+		return face
+	}
+}
+
+func (s *FaceSelector) isUsed(name string) bool {
+	for _, used := range s.used {
+		if used == name {
+			return true
+		}
+	}
+	return false
+}
+
+// weight is the face's weight after the recent use penalty
+func (s *FaceSelector) weight(f *faceImage) float64 {
+	if posts, ok := s.recent[f.name]; ok {
+		return f.weight * float64(posts+1) / float64(s.window+1)
+	}
+	return f.weight
+}
+
+// weightedChoice returns the index of a random face with the probability
+// of each face proportional to its weight
+func (s *FaceSelector) weightedChoice(faces []faceImage) int {
+	var total float64
+	for i := range faces {
+		total += s.weight(&faces[i])
+	}
+	if total <= 0 {
+		return s.rand.Intn(len(faces))
+	}
+	r := s.rand.Float64() * total
+	for i := range faces {
+		w := s.weight(&faces[i])
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(faces) - 1
+}
@@ -0,0 +1,95 @@
+package faceutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+// StoryOptions configure the layout of story images
+type StoryOptions struct {
+	// Width and Height are the size of the story
+	Width  int
+	Height int
+
+	// Caption and Credit are drawn in a text block under the photo.
+	// The block is left out when both are empty.
+	Caption string
+	Credit  string
+}
+
+// Story lays the image out on a story sized canvas. The photo is scaled to
+// fit and centered over a blurred copy of itself which fills the canvas.
+func Story(img image.Image, opt StoryOptions) *image.NRGBA {
+	var (
+		w      = opt.Width
+		h      = opt.Height
+		margin = w / 18
+		pad    = w / 36
+		inner  = w - 2*margin - 2*pad
+
+		captionFace = fontFace(boldFont, float64(w)/20)
+		creditFace  = fontFace(regularFont, float64(w)/32)
+
+		captionLines []string
+		creditLines  []string
+		textHeight   int
+	)
+	if opt.Caption != "" {
+		captionLines = wrapText(captionFace, opt.Caption, inner)
+		textHeight += len(captionLines) * lineHeight(captionFace)
+	}
+	if opt.Credit != "" {
+		creditLines = wrapText(creditFace, opt.Credit, inner)
+		if textHeight > 0 {
+			textHeight += pad
+		}
+		textHeight += len(creditLines) * lineHeight(creditFace)
+	}
+	blockHeight := 0
+	if textHeight > 0 {
+		blockHeight = textHeight + 2*pad
+	}
+
+	// the photo is scaled up or down to fill the room left over by the
+	// text. Instagram photos are often smaller than the story.
+	var (
+		b        = img.Bounds()
+		photoMax = image.Pt(w-2*margin, maxInt(h-2*margin-blockHeight-margin, 1))
+		photo    *image.NRGBA
+	)
+	if b.Dy()*photoMax.X > photoMax.Y*b.Dx() {
+		photo = imaging.Resize(img, 0, photoMax.Y, imaging.Lanczos)
+	} else {
+		photo = imaging.Resize(img, photoMax.X, 0, imaging.Lanczos)
+	}
+	content := photo.Rect.Dy()
+	if blockHeight > 0 {
+		content += margin + blockHeight
+	}
+
+	// the background is darkened so the photo and text stand out
+	canvas := imaging.Blur(imaging.Fill(img, w, h, imaging.Center, imaging.Linear), float64(w)/30)
+	draw.Draw(canvas, canvas.Rect, image.NewUniform(color.NRGBA{0, 0, 0, 80}), image.ZP, draw.Over)
+
+	top := (h - content) / 2
+	photoAt := image.Pt((w-photo.Rect.Dx())/2, top)
+	draw.Draw(canvas, photo.Rect.Add(photoAt), photo, image.ZP, draw.Over)
+	if blockHeight == 0 {
+		return canvas
+	}
+
+	block := image.Rect(margin, top+photo.Rect.Dy()+margin, w-margin, top+content)
+	draw.Draw(canvas, block, image.NewUniform(color.NRGBA{0, 0, 0, 160}), image.ZP, draw.Over)
+	text := block.Inset(pad)
+	if len(captionLines) > 0 {
+		drawTextLines(canvas, captionFace, captionLines, text, color.White)
+		text.Min.Y += len(captionLines)*lineHeight(captionFace) + pad
+	}
+	if len(creditLines) > 0 {
+		drawTextLines(canvas, creditFace, creditLines, text, color.NRGBA{220, 220, 220, 255})
+	}
+	return canvas
+}
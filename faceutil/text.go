@@ -0,0 +1,84 @@
+package faceutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	regularFont = mustParseFont(goregular.TTF)
+	boldFont    = mustParseFont(gobold.TTF)
+)
+
+func mustParseFont(ttf []byte) *truetype.Font {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// fontFace returns a face for the font with a height of size pixels
+func fontFace(f *truetype.Font, size float64) font.Face {
+	return truetype.NewFace(f, &truetype.Options{
+		Size:    size,
+		Hinting: font.HintingFull,
+	})
+}
+
+// wrapText splits the text into lines no wider than width. Words which are
+// wider than width get their own line.
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		var line string
+		for _, word := range strings.Fields(paragraph) {
+			next := word
+			if line != "" {
+				next = line + " " + word
+			}
+			if line != "" && font.MeasureString(face, next).Ceil() > width {
+				lines = append(lines, line)
+				next = word
+			}
+			line = next
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// lineHeight is the distance between the baselines of the face's lines
+func lineHeight(face font.Face) int {
+	return face.Metrics().Height.Ceil()
+}
+
+// drawTextLines draws the lines centered in the rect starting at its top
+func drawTextLines(canvas draw.Image, face font.Face, lines []string, r image.Rectangle, c color.Color) {
+	var (
+		height = lineHeight(face)
+		ascent = face.Metrics().Ascent.Ceil()
+	)
+	for i, line := range lines {
+		var (
+			w = font.MeasureString(face, line).Ceil()
+			x = r.Min.X + (r.Dx()-w)/2
+			y = r.Min.Y + ascent + i*height
+		)
+		d := font.Drawer{
+			Dst:  canvas,
+			Src:  image.NewUniform(c),
+			Face: face,
+			Dot:  fixed.P(x, y),
+		}
+		d.DrawString(line)
+	}
+}
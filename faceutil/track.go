@@ -0,0 +1,159 @@
+package faceutil
+
+import (
+	"image"
+	"sort"
+)
+
+// TrackOptions configure how faces are linked across the frames of an animation
+type TrackOptions struct {
+	// IoU is the minimum intersection over union between a face and
+	// the last face in a track for it to be added to the track
+	IoU float64
+
+	// MaxGap is the number of frames a track can miss before it ends.
+	// The faces in the missed frames are interpolated.
+	MaxGap int
+
+	// Smooth is the number of frames on either side of a face which
+	// are averaged to keep the face from jittering
+	Smooth int
+}
+
+// Track is a face followed through an animation. It has a face in
+// every frame from Start to Start+len(Faces)-1.
+type Track struct {
+	Start int
+	Faces []Detection
+}
+
+// Pose returns the most common pose of the faces in the track
+func (t *Track) Pose() Pose {
+	counts := map[Pose]int{}
+	best := PoseFrontal
+	for _, face := range t.Faces {
+		counts[face.Pose]++
+		if counts[face.Pose] > counts[best] {
+			best = face.Pose
+		}
+	}
+	return best
+}
+
+// Width returns the median width of the faces in the track
+func (t *Track) Width() int {
+	widths := make([]int, len(t.Faces))
+	for i, face := range t.Faces {
+		widths[i] = face.Rect.Dx()
+	}
+	sort.Ints(widths)
+	return widths[len(widths)/2]
+}
+
+// TrackFaces links the faces detected in each frame into tracks. The faces
+// in each frame are matched to the tracks which were seen most recently
+// starting with the pairs that overlap the most. Faces which don't match
+// start new tracks.
+func TrackFaces(frames [][]Detection, opt TrackOptions) []*Track {
+	var (
+		tracks []*Track
+		active []*Track
+	)
+	for f, faces := range frames {
+		// drop the tracks which have been missing for too long
+		var alive []*Track
+		for _, t := range active {
+			if f-t.end() <= opt.MaxGap+1 {
+				alive = append(alive, t)
+			}
+		}
+		active = alive
+
+		last := make([]image.Rectangle, len(active))
+		for i, t := range active {
+			last[i] = t.Faces[len(t.Faces)-1].Rect
+		}
+		detected := make([]image.Rectangle, len(faces))
+		for i, face := range faces {
+			detected[i] = face.Rect
+		}
+		matched := map[int]bool{}
+		for _, m := range matchFaces(last, detected, opt.IoU) {
+			active[m.truth].extend(f, faces[m.detected])
+			matched[m.detected] = true
+		}
+		for i, face := range faces {
+			if matched[i] {
+				continue
+			}
+			t := &Track{Start: f, Faces: []Detection{face}}
+			tracks = append(tracks, t)
+			active = append(active, t)
+		}
+	}
+	for _, t := range tracks {
+		t.smooth(opt.Smooth)
+	}
+	return tracks
+}
+
+// end returns the last frame the track has a face in
+func (t *Track) end() int {
+	return t.Start + len(t.Faces) - 1
+}
+
+// extend adds the face in frame f and interpolates the missed frames
+func (t *Track) extend(f int, face Detection) {
+	var (
+		prev = t.Faces[len(t.Faces)-1]
+		gap  = f - t.end()
+	)
+	for i := 1; i < gap; i++ {
+		t.Faces = append(t.Faces, lerpDetection(prev, face, float64(i)/float64(gap)))
+	}
+	t.Faces = append(t.Faces, face)
+}
+
+// smooth replaces each face's rect and angle with the average of the
+// faces within radius frames of it
+func (t *Track) smooth(radius int) {
+	if radius <= 0 {
+		return
+	}
+	smoothed := make([]Detection, len(t.Faces))
+	for i := range t.Faces {
+		var (
+			lo                    = maxInt(i-radius, 0)
+			hi                    = minInt(i+radius, len(t.Faces)-1)
+			n                     = float64(hi - lo + 1)
+			x0, y0, x1, y1, angle float64
+		)
+		for _, face := range t.Faces[lo : hi+1] {
+			x0 += float64(face.Rect.Min.X)
+			y0 += float64(face.Rect.Min.Y)
+			x1 += float64(face.Rect.Max.X)
+			y1 += float64(face.Rect.Max.Y)
+			angle += face.Angle
+		}
+		smoothed[i] = t.Faces[i]
+		smoothed[i].Rect = image.Rect(round(x0/n), round(y0/n), round(x1/n), round(y1/n))
+		smoothed[i].Angle = angle / n
+	}
+	t.Faces = smoothed
+}
+
+// lerpDetection returns the face between a and b at t [0-1]
+func lerpDetection(a, b Detection, t float64) Detection {
+	lerp := func(a, b int) int {
+		return round(float64(a) + float64(b-a)*t)
+	}
+	face := a
+	face.Rect = image.Rect(
+		lerp(a.Rect.Min.X, b.Rect.Min.X),
+		lerp(a.Rect.Min.Y, b.Rect.Min.Y),
+		lerp(a.Rect.Max.X, b.Rect.Max.X),
+		lerp(a.Rect.Max.Y, b.Rect.Max.Y),
+	)
+	face.Angle = a.Angle + (b.Angle-a.Angle)*t
+	return face
+}
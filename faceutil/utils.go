@@ -7,7 +7,7 @@ import (
 	"math"
 )
 
-type ByCenterY []image.Rectangle
+type ByCenterY []Detection
 
 func (b ByCenterY) Len() int {
 	return len(b)
@@ -19,8 +19,8 @@ func (b ByCenterY) Swap(i, j int) {
 
 func (b ByCenterY) Less(i, j int) bool {
 	var (
-		p1 = getRectCenter(b[i])
-		p2 = getRectCenter(b[j])
+		p1 = getRectCenter(b[i].Rect)
+		p2 = getRectCenter(b[j].Rect)
 	)
 	return p1.Y < p2.Y
 }
@@ -76,6 +76,35 @@ func drawRect(img *image.NRGBA, rect image.Rectangle, c color.Color) {
 	}
 }
 
+func drawPolygon(img *image.NRGBA, points [4]image.Point, c color.Color) {
+	for i, p := range points {
+		drawLine(img, p, points[(i+1)%len(points)], c)
+	}
+}
+
+func drawLine(img *image.NRGBA, p1, p2 image.Point, c color.Color) {
+	var (
+		dx        = p2.X - p1.X
+		dy        = p2.Y - p1.Y
+		steps     = maxInt(absInt(dx), absInt(dy))
+		thickness = 2
+	)
+	for i := 0; i <= steps; i++ {
+		var (
+			x = p1.X
+			y = p1.Y
+		)
+		if steps > 0 {
+			x += round(float64(dx*i) / float64(steps))
+			y += round(float64(dy*i) / float64(steps))
+		}
+		for t := 0; t < thickness; t++ {
+			img.Set(x+t, y, c)
+			img.Set(x, y+t, c)
+		}
+	}
+}
+
 func getRectCenter(rect image.Rectangle) image.Point {
 	return image.Point{
 		X: rect.Min.X + rect.Dx()/2,
@@ -84,10 +113,13 @@ func getRectCenter(rect image.Rectangle) image.Point {
 }
 
 func getRectCenteredIn(child, parent image.Rectangle) image.Rectangle {
+	return getRectCenteredAt(child, getRectCenter(parent))
+}
+
+func getRectCenteredAt(child image.Rectangle, center image.Point) image.Rectangle {
 	var (
-		center = getRectCenter(parent)
-		halfX  = child.Dx() / 2
-		halfY  = child.Dy() / 2
+		halfX = child.Dx() / 2
+		halfY = child.Dy() / 2
 	)
 	return image.Rectangle{
 		Min: image.Point{
@@ -100,3 +132,47 @@ func getRectCenteredIn(child, parent image.Rectangle) image.Rectangle {
 		},
 	}
 }
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt(a, lo, hi int) int {
+	return maxInt(lo, minInt(a, hi))
+}
+
+// cropCentered returns the largest rect with the target aspect ratio
+// centered on c as much as the bounds allow
+func cropCentered(b image.Rectangle, c image.Point, target float64) image.Rectangle {
+	var (
+		w = b.Dx()
+		h = b.Dy()
+	)
+	if float64(w)/float64(h) > target {
+		w = round(float64(h) * target)
+	} else {
+		h = round(float64(w) / target)
+	}
+	origin := image.Pt(
+		clampInt(c.X-w/2, b.Min.X, b.Max.X-w),
+		clampInt(c.Y-h/2, b.Min.Y, b.Max.Y-h),
+	)
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}
+}
@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package imgstore
+
+import _ "github.com/mattn/go-sqlite3"
+
+const driverName = "sqlite3"
@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package imgstore
+
+import _ "modernc.org/sqlite"
+
+const driverName = "sqlite"
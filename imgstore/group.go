@@ -0,0 +1,111 @@
+package imgstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/icholy/nick_bot/model"
+)
+
+// Grouping is how the records in a collage are related
+type Grouping int
+
+const (
+	// GroupUser picks records posted by the same user
+	GroupUser Grouping = iota
+
+	// GroupWeek picks records posted in the same week
+	GroupWeek
+)
+
+func (g Grouping) String() string {
+	switch g {
+	case GroupUser:
+		return "user"
+	case GroupWeek:
+		return "week"
+	default:
+		return "invalid"
+	}
+}
+
+// Set implements flag.Value
+func (g *Grouping) Set(s string) error {
+	grouping, err := ParseGrouping(s)
+	if err != nil {
+		return err
+	}
+	*g = grouping
+	return nil
+}
+
+func ParseGrouping(s string) (Grouping, error) {
+	switch s {
+	case "user":
+		return GroupUser, nil
+	case "week":
+		return GroupWeek, nil
+	default:
+		return 0, fmt.Errorf("invalid grouping: %s", s)
+	}
+}
+
+const weekSeconds = 7 * 24 * 60 * 60
+
+// SearchGroup returns the count best available records from a random group
+// which has at least count of them. The records are ordered by faces then
+// likes.
+func (s *Store) SearchGroup(minFaces, count int, grouping Grouping) ([]*model.Record, error) {
+	log.Debugf("imgstore: searching for %d record(s) grouped by %s", count, grouping)
+	var key string
+	switch grouping {
+	case GroupUser:
+		key = "user_id"
+	case GroupWeek:
+		key = fmt.Sprintf("posted_at / %d", weekSeconds)
+	default:
+		return nil, fmt.Errorf("grouping not implemented: %s", grouping)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	var group int64
+	if err := s.db.QueryRow(`
+		SELECT `+key+` AS grp
+		FROM media
+		WHERE state = ? AND face_count >= ?
+		GROUP BY grp
+		HAVING COUNT(1) >= ?
+		ORDER BY RANDOM()
+		LIMIT 1
+	`, model.MediaAvailable, minFaces, count,
+	).Scan(&group); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT *
+		FROM media
+		WHERE state = ? AND face_count >= ? AND `+key+` = ?
+		ORDER BY face_count DESC, like_count DESC
+		LIMIT ?
+	`, model.MediaAvailable, minFaces, group, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*model.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return recs, nil
+}
@@ -6,8 +6,6 @@ import (
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/icholy/nick_bot/model"
 )
 
@@ -17,7 +15,7 @@ type Store struct {
 }
 
 func Open(database string) (*Store, error) {
-	db, err := sql.Open("sqlite3", database)
+	db, err := sql.Open(driverName, database)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +47,11 @@ func (s *Store) initDatabase() error {
 			state       INTEGER
 		);
 		CREATE INDEX IF NOT EXISTS media_id_idx ON media (media_id);
+		CREATE TABLE IF NOT EXISTS face_usage (
+			media_id    TEXT,
+			face        TEXT,
+			used_at     INTEGER
+		);
 		COMMIT;
 	`)
 	return err
@@ -107,7 +110,7 @@ func (s *Store) SetState(id string, state model.MediaState) error {
 		return err
 	}
 	if n == 0 {
-		return fmt.Errorf("media not found: %s, %d", id)
+		return fmt.Errorf("media not found: %s", id)
 	}
 	return nil
 }
@@ -149,7 +152,12 @@ func (s *Store) ResetStates() error {
 	return err
 }
 
-func scanRecord(row *sql.Row) (*model.Record, error) {
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecord(row scanner) (*model.Record, error) {
 	var (
 		rec      model.Record
 		postedAt int64
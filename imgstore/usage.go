@@ -0,0 +1,62 @@
+package imgstore
+
+import "time"
+
+// PutFaceUsage records the faces drawn on a posted photo
+func (s *Store) PutFaceUsage(mediaID string, faces []string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, face := range faces {
+		if _, err := tx.Exec(
+			`INSERT INTO face_usage VALUES (?, ?, ?)`,
+			mediaID, face, now,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecentFaceUsage returns the faces drawn on the most recent posts.
+// The most recent post is first.
+func (s *Store) RecentFaceUsage(posts int) ([][]string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	rows, err := s.db.Query(`
+		SELECT media_id, face
+		FROM face_usage
+		ORDER BY rowid DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		usage [][]string
+		last  string
+	)
+	for rows.Next() {
+		var mediaID, face string
+		if err := rows.Scan(&mediaID, &face); err != nil {
+			return nil, err
+		}
+		if len(usage) == 0 || mediaID != last {
+			if len(usage) == posts {
+				break
+			}
+			usage = append(usage, nil)
+			last = mediaID
+		}
+		usage[len(usage)-1] = append(usage[len(usage)-1], face)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -13,6 +13,10 @@ import (
 
 var ErrInvalidResponseStatus = errors.New("instagram: invalid response status")
 
+// ErrStoriesUnsupported is returned by UploadStory because goinsta
+// can only configure uploads as feed posts
+var ErrStoriesUnsupported = errors.New("instagram: story uploads aren't supported")
+
 type Session struct {
 	insta *goinsta.Instagram
 }
@@ -151,6 +155,13 @@ func (s *Session) UploadPhoto(imgPath string, caption string) error {
 	return nil
 }
 
+// UploadStory publishes the photo as a story. goinsta doesn't expose the
+// story configure endpoint, so this always returns ErrStoriesUnsupported
+// for now.
+func (s *Session) UploadStory(imgPath string) error {
+	return ErrStoriesUnsupported
+}
+
 func (Session) cleanURL(rawurl string) (string, error) {
 	u, err := url.Parse(rawurl)
 	if err != nil {
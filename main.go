@@ -1,25 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"image/jpeg"
 	_ "image/png"
+	"io"
+	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/evalphobia/logrus_sentry"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/robfig/cron"
 
 	"github.com/icholy/nick_bot/facebot"
 	"github.com/icholy/nick_bot/faceutil"
 	"github.com/icholy/nick_bot/imgstore"
+	"github.com/icholy/nick_bot/instagram"
 	"github.com/icholy/nick_bot/model"
 )
 
@@ -30,15 +34,32 @@ var (
 	upload     = flag.Bool("upload", false, "enable photo uploading")
 	testimg    = flag.String("test.image", "", "test image")
 	testdir    = flag.String("test.dir", "", "test a directory of images")
-	facedir    = flag.String("face.dir", "faces", "directory to load faces from")
+	seed       = flag.Int64("seed", 0, "random seed used to pick the faces (0 uses the current time)")
+	facedir    = flag.String("face.dir", "faces", "face pack directory or zip archive to load faces from")
+	history    = flag.Int("face.history", 10, "number of recent posts whose faces are less likely to be reused")
 	httpport   = flag.String("http.port", "", "http port (example :8080)")
 	autofollow = flag.Bool("auto.follow", false, "auto follow random people")
 	sentryDSN  = flag.String("sentry.dsn", "", "Sentry DSN")
 
+	detectorName = flag.String("detector", faceutil.DefaultDetector, "face detector (opencv or go)")
+
+	evaldir   = flag.String("eval.dir", "", "evaluate face detection on a directory of annotated images")
+	evaltruth = flag.String("eval.truth", "", "ground truth JSON or JSONL file (default eval.dir/faces.json)")
+	evaliou   = flag.Float64("eval.iou", 0.5, "minimum intersection over union for a detection to match a face")
+	evalsweep = flag.String("eval.sweep", "", "flag values to grid search (example min.neighboor=3,5,9;haar.scale=1.05,1.1)")
+
+	buildmargin = flag.Float64("build.margin", 60, "the margin faces build crops around the faces as a percentage of their size")
+	buildsize   = flag.Int("build.size", 400, "the width of the faces written by faces build")
+	buildmin    = flag.Int("build.min.size", 80, "faces build skips faces narrower than this many pixels")
+	builddupes  = flag.Int("build.dupes", 6, "faces build drops faces whose hashes differ by this many bits or fewer")
+	buildcutout = flag.Bool("build.cutout", true, "faces build removes the background around the faces (requires cgo)")
+
 	resetStore = flag.Bool("reset.store", false, "mark all store records as available")
 	storefile  = flag.String("store", "store.db", "the store file")
 
 	postNow      = flag.Bool("post.now", false, "post and exit")
+	postStory    = flag.Bool("post.story", false, "post to the story instead of the feed")
+	postCollage  = flag.Bool("post.collage", false, "post a collage of related photos")
 	postInterval = flag.Duration("post.interval", 0, "how often to post")
 )
 
@@ -68,7 +89,40 @@ func main() {
 		log.AddHook(hook)
 	}
 
-	faceutil.MustLoadFaces(*facedir)
+	rand.Seed(time.Now().UnixNano())
+
+	eyes, err := loadEyeDetector()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c, ok := eyes.(io.Closer); ok {
+		defer c.Close()
+	}
+	detector, err := loadDetector()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c, ok := detector.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// commands don't need the face pack or the store
+	if flag.NArg() > 0 {
+		if err := runCommand(detector, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	faces := faceutil.MustLoadFacePack(*facedir, eyes)
+	modes, err := loadModes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	renderer, err := faceutil.NewRenderer(renderOptions(detector, faces, eyes, modes))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	store, err := imgstore.Open(*storefile)
 	if err != nil {
@@ -82,21 +136,34 @@ func main() {
 			log.Fatal(err)
 		}
 	case *testimg != "":
-		if err := testImage(*testimg, os.Stdout); err != nil {
+		if err := testImage(renderer, *testimg, os.Stdout); err != nil {
 			log.Fatal(err)
 		}
 	case *testdir != "":
-		if err := testImageDir(*testdir); err != nil {
+		if err := testImageDir(renderer, *testdir); err != nil {
+			log.Fatal(err)
+		}
+	case *evaldir != "":
+		truthfile := *evaltruth
+		if truthfile == "" {
+			truthfile = filepath.Join(*evaldir, "faces.json")
+		}
+		if *evalsweep != "" {
+			err = sweepDetector(faces, eyes, *evaldir, truthfile, *evalsweep, *evaliou, os.Stdout)
+		} else {
+			err = evalDetector(renderer, *evaldir, truthfile, *evaliou, os.Stdout)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 	default:
-		if err := startBot(store); err != nil {
+		if err := startBot(store, renderer); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func startBot(store *imgstore.Store) error {
+func startBot(store *imgstore.Store, renderer *faceutil.Renderer) error {
 
 	fmt.Println(banner)
 
@@ -114,6 +181,18 @@ func startBot(store *imgstore.Store) error {
 		AutoFollow: *autofollow,
 		Captions:   captions,
 		Store:      store,
+		Renderer:   renderer,
+		Export:     exportOptions(),
+
+		Collage:      collageOptions(),
+		CollageCount: *collageCount,
+		CollageGroup: collageGroup,
+
+		Story:        storyOptions(),
+		StoryCaption: *storyCaption,
+		DrawText:     *textOutput,
+
+		FaceHistory: *history,
 	})
 	go bot.Run()
 
@@ -121,20 +200,27 @@ func startBot(store *imgstore.Store) error {
 		go runHTTPServer(bot, store)
 	}
 
-	doPost := func() {
-		log.Infof("trying to post")
-		if err := bot.Post(); err != nil {
-			log.Errorf("posting: %s", err)
-		}
+	action := "post"
+	switch {
+	case *postStory:
+		action = "story"
+	case *postCollage:
+		action = "collage"
 	}
 
 	switch {
 	case *postNow:
-		doPost()
+		if err := checkAction(action); err != nil {
+			return err
+		}
+		runAction(bot, action)
 		return nil
 	case *postInterval != 0:
+		if err := checkAction(action); err != nil {
+			return err
+		}
 		for {
-			doPost()
+			runAction(bot, action)
 			time.Sleep(*postInterval)
 		}
 	default:
@@ -148,17 +234,88 @@ func startBot(store *imgstore.Store) error {
 			if len(line) == 0 {
 				continue
 			}
-			if err := c.AddFunc(line, doPost); err != nil {
+			entry, err := parseScheduleLine(line)
+			if err != nil {
+				return err
+			}
+			if err := checkAction(entry.action); err != nil {
+				return err
+			}
+			b := bot
+			if entry.policy != nil {
+				b = bot.WithPolicy(*entry.policy)
+			}
+			if err := c.AddFunc(entry.spec, func() { runAction(b, entry.action) }); err != nil {
 				return err
 			}
 		}
 		c.Start()
 		select {}
 	}
+}
 
+// actions are the ways the bot can post
+var actions = map[string]func(*facebot.Bot) error{
+	"post":    (*facebot.Bot).Post,
+	"story":   (*facebot.Bot).PostStory,
+	"collage": (*facebot.Bot).PostCollage,
+}
+
+// checkAction returns an error for actions which can't be uploaded yet.
+// Otherwise every scheduled story would reject the photo it picked.
+func checkAction(action string) error {
+	if action == "story" && *upload {
+		return instagram.ErrStoriesUnsupported
+	}
 	return nil
 }
 
+func runAction(bot *facebot.Bot, action string) {
+	log.Infof("trying to %s", action)
+	if err := actions[action](bot); err != nil {
+		log.Errorf("%s: %s", action, err)
+	}
+}
+
+// scheduleEntry is a line from the schedule file
+type scheduleEntry struct {
+	spec   string
+	action string
+
+	// policy overrides the -policy flag when it's not nil
+	policy *faceutil.Policy
+}
+
+// parseScheduleLine splits a schedule line into the cron spec, the action,
+// and the options. The action (post, story, or collage) and the
+// policy=<policy> option are optional and come after the spec.
+func parseScheduleLine(line string) (*scheduleEntry, error) {
+	var (
+		fields = strings.Fields(line)
+		entry  = &scheduleEntry{action: "post"}
+	)
+	for len(fields) > 0 {
+		last := fields[len(fields)-1]
+		if _, ok := actions[last]; ok {
+			entry.action = last
+		} else if strings.HasPrefix(last, "policy=") {
+			p, err := faceutil.ParsePolicy(strings.TrimPrefix(last, "policy="))
+			if err != nil {
+				return nil, err
+			}
+			entry.policy = &p
+		} else {
+			break
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("schedule line is missing the cron spec: %s", line)
+	}
+	entry.spec = strings.Join(fields, " ")
+	return entry, nil
+}
+
 func runHTTPServer(bot *facebot.Bot, store *imgstore.Store) {
 	http.HandleFunc("/demo", func(w http.ResponseWriter, r *http.Request) {
 		img, err := bot.Demo()
@@ -172,6 +329,15 @@ func runHTTPServer(bot *facebot.Bot, store *imgstore.Store) {
 			return
 		}
 	})
+	http.HandleFunc("/demo.gif", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := bot.DemoGIF(&buf, gifOptions()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "image/gif")
+		buf.WriteTo(w)
+	})
 	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 		stats, err := store.Stats(model.MediaAvailable)
 		if err != nil {
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icholy/nick_bot/faceutil"
+	"github.com/icholy/nick_bot/imgstore"
+)
+
+var (
+	minNeighboor = flag.Int("min.neighboor", 9, "the lower this number is, the more faces will be found")
+	haarCascade  = flag.String("haar", "haarcascade_frontalface_alt.xml", "The location of the Haar Cascade XML configuration to be provided to the detector.")
+	haarPoolSize = flag.Int("haar.pool", runtime.NumCPU(), "The number of cascades the opencv detector can use concurrently")
+	haarScale    = flag.Float64("haar.scale", 1.1, "how much the detection window grows between scales")
+	haarMinSize  = flag.Int("haar.min.size", 0, "the smallest face width in pixels to look for")
+	haarMaxSize  = flag.Int("haar.max.size", 0, "the largest face width in pixels to look for (0 is unlimited)")
+	ensemble     = flag.String("ensemble", "", "comma separated cascades to combine (alt, alt2, default, profile, profile.mirror)")
+	cascadeDir   = flag.String("cascade.dir", ".", "directory to load the ensemble cascades from")
+	overlap      = flag.Float64("overlap", 0.3, "maximum intersection over union between detected faces")
+	rotations    = flag.String("rotations", "", "comma separated angles to rotate the image by when looking for tilted faces (example -30,-15,15,30)")
+	detectSize   = flag.Int("detect.size", 0, "downscale images so their longest side is at most this many pixels before detecting (0 is full size)")
+	upscaleCrops = flag.Float64("detect.upscale", 0, "also look for small faces in overlapping crops upscaled by this factor")
+	eyeCascade   = flag.String("eyes", "", "The location of an eye cascade (example haarcascade_eye.xml) used to line the faces up with the detected eyes")
+	eyeNeighboor = flag.Int("eyes.min.neighboor", 3, "the lower this number is, the more eyes will be found")
+	margin       = flag.Float64("margin", 60.0, "The face rectangle margin")
+	faceOpacity  = flag.Float64("face.opacity", 1.0, "Face opacity [0-255]")
+	featherWidth = flag.Float64("blend.feather", 0.2, "the width of the feathered edge as a fraction of the face")
+	seamlessIter = flag.Int("blend.iterations", 300, "the number of iterations used to solve the seamless blend")
+	colorMatch   = flag.Float64("color.match", 0, "how much to match the face's lighting and skin tone to the photo [0-1]")
+
+	filterMinSize   = flag.Float64("filter.min.size", 0, "reject faces narrower than this fraction of the image (0 is disabled, example 0.03)")
+	filterMinSkin   = flag.Float64("filter.min.skin", 0, "reject faces with less than this fraction of skin colored pixels (0 is disabled, example 0.1)")
+	filterMaxAspect = flag.Float64("filter.max.aspect", 0, "reject faces with a longer to shorter side ratio above this (0 is disabled, example 1.5)")
+	filterMinScore  = flag.Float64("filter.min.score", 0, "reject faces with a lower detection score (0 is disabled)")
+
+	shouldDrawFace  = flag.Bool("draw.face", true, "Draw the face")
+	shouldDrawRects = flag.Bool("draw.rects", false, "Show the detection rectangles")
+
+	modes          = flag.String("mode", "overlay", "how faces are replaced, largest first (overlay, blur, pixelate, sticker, inpaint, or accessory with an optional count, example overlay+accessory:1,blur)")
+	modeBlur       = flag.Float64("mode.blur", 0.08, "the blur radius as a fraction of the face width")
+	modeBlocks     = flag.Int("mode.blocks", 8, "the number of blocks across a pixelated face")
+	modeSticker    = flag.String("mode.sticker", "", "the image stickers are made from (default smiley)")
+	accessoryDir   = flag.String("accessory.dir", "accessories", "the accessory pack used by the accessory mode (directory or zip)")
+	accessoryCount = flag.Int("accessory.count", 1, "the number of accessories on each face")
+
+	gifOutput   = flag.Bool("gif", false, "write the test images as animated before and after GIFs")
+	gifFrames   = flag.Int("gif.frames", 10, "the number of frames in a fade")
+	gifDelay    = flag.Duration("gif.delay", 100*time.Millisecond, "the time between the frames of the transition")
+	gifHold     = flag.Duration("gif.hold", 1500*time.Millisecond, "how long the before and after frames are shown")
+	gifColors   = flag.Int("gif.colors", 256, "the number of colors in the GIF palette")
+	gifMaxSize  = flag.Int("gif.size", 480, "the longest side of the GIF in pixels (0 is unlimited)")
+	gifMaxBytes = flag.Int("gif.bytes", 4<<20, "the largest the GIF can be in bytes (0 is unlimited)")
+
+	trackIoU    = flag.Float64("track.iou", 0.3, "minimum intersection over union for a face to continue a track in an animation")
+	trackGap    = flag.Int("track.gap", 3, "number of frames a tracked face can be missing before its track ends")
+	trackSmooth = flag.Int("track.smooth", 2, "number of frames on either side averaged to smooth tracked faces")
+
+	exportOutput     = flag.Bool("export", false, "export the test images the way the bot does before uploading")
+	exportMinAspect  = flag.Float64("export.min.aspect", 0.8, "the narrowest width to height ratio of exported photos (0 is unlimited)")
+	exportMaxAspect  = flag.Float64("export.max.aspect", 1.91, "the widest width to height ratio of exported photos (0 is unlimited)")
+	exportWidth      = flag.Int("export.width", 1080, "the width of exported photos in pixels (0 keeps the original width)")
+	exportMaxBytes   = flag.Int("export.bytes", 1<<20, "the largest an exported photo can be in bytes (0 is unlimited)")
+	exportQuality    = flag.Int("export.quality", 95, "the highest JPEG quality tried when exporting")
+	exportMinQuality = flag.Int("export.min.quality", 60, "the lowest JPEG quality tried when exporting")
+
+	collageOutput = flag.Bool("collage", false, "write a collage of the test directory's images")
+	collageCount  = flag.Int("collage.count", 4, "the number of photos in a collage")
+	collageSize   = flag.Int("collage.size", 1080, "the width and height of collages in pixels")
+	collageGap    = flag.Int("collage.gap", 8, "the space between the photos in a collage in pixels")
+
+	storyOutput  = flag.Bool("story", false, "lay the test images out as stories")
+	storyWidth   = flag.Int("story.width", 1080, "the width of stories in pixels")
+	storyHeight  = flag.Int("story.height", 1920, "the height of stories in pixels")
+	storyCaption = flag.Bool("story.caption", true, "add a caption from captions.txt to stories")
+
+	textOutput  = flag.Bool("text", false, "draw a caption from captions.txt on the photos")
+	textLines   = flag.Int("text.lines", 2, "the most lines a drawn caption is wrapped onto")
+	textMinSize = flag.Float64("text.min.size", 0.04, "the smallest font size of drawn captions as a fraction of the photo width")
+	textMaxSize = flag.Float64("text.max.size", 0.1, "the largest font size of drawn captions as a fraction of the photo width")
+
+	blendMode     = faceutil.BlendOverlay
+	gifTransition = faceutil.TransitionFade
+	exportFit     = faceutil.FitCrop
+	collageGroup  = imgstore.GroupUser
+	policy        faceutil.Policy
+	textStyle     = faceutil.TextMeme
+	textPosition  = faceutil.TextAuto
+)
+
+func init() {
+	flag.Var(&blendMode, "blend", "how faces are blended into the photo (overlay, feather, or seamless)")
+	flag.Var(&gifTransition, "gif.transition", "how the GIF goes from the original photo to the nicked one (fade or popin)")
+	flag.Var(&exportFit, "export.fit", "how exported photos are fit to the aspect ratios (crop or pad)")
+	flag.Var(&policy, "policy", "which faces are replaced (all, largest:N, random, random:N, or allbutone, with optional min:PX, group:N, and small:PX)")
+	flag.Var(&collageGroup, "collage.group", "how the photos in a collage are related (user or week)")
+	flag.Var(&textStyle, "text.style", "how captions are drawn on the photos (meme or subtitle)")
+	flag.Var(&textPosition, "text.position", "the edge captions are drawn at (auto, top, or bottom)")
+}
+
+// detectorOptions maps the detector flags onto the detector options
+func detectorOptions() (faceutil.DetectorOptions, error) {
+	angles, err := parseAngles(*rotations)
+	if err != nil {
+		return faceutil.DetectorOptions{}, err
+	}
+	opt := faceutil.DetectorOptions{
+		Name:    *detectorName,
+		Cascade: *haarCascade,
+		CascadeOptions: faceutil.CascadeOptions{
+			MinNeighbor: *minNeighboor,
+			ScaleFactor: *haarScale,
+			MinSize:     *haarMinSize,
+			MaxSize:     *haarMaxSize,
+		},
+		PoolSize:   *haarPoolSize,
+		CascadeDir: *cascadeDir,
+		Overlap:    *overlap,
+		Rotations:  angles,
+		Size:       *detectSize,
+		Upscale:    *upscaleCrops,
+	}
+	if *ensemble != "" {
+		opt.Ensemble = strings.Split(*ensemble, ",")
+	}
+	return opt, nil
+}
+
+// loadDetector creates the detector described by the flags
+func loadDetector() (faceutil.Detector, error) {
+	opt, err := detectorOptions()
+	if err != nil {
+		return nil, err
+	}
+	return faceutil.LoadDetector(opt)
+}
+
+// loadEyeDetector creates the -eyes detector. It returns nil
+// when the flag isn't set.
+func loadEyeDetector() (faceutil.Detector, error) {
+	if *eyeCascade == "" {
+		return nil, nil
+	}
+	return faceutil.LoadDetector(faceutil.DetectorOptions{
+		Name:    *detectorName,
+		Cascade: *eyeCascade,
+		CascadeOptions: faceutil.CascadeOptions{
+			MinNeighbor: *eyeNeighboor,
+			ScaleFactor: *haarScale,
+		},
+		PoolSize: *haarPoolSize,
+	})
+}
+
+// renderOptions maps the drawing and filtering flags onto the render options
+func renderOptions(d faceutil.Detector, faces *faceutil.FacePack, eyes faceutil.Detector, modes faceutil.ModeRules) faceutil.RenderOptions {
+	opt := faceutil.RenderOptions{
+		Detector: d,
+		Faces:    faces,
+		Eyes:     eyes,
+		Overlap:  *overlap,
+		Filter: &faceutil.Filter{
+			MinSize:   *filterMinSize,
+			MinSkin:   *filterMinSkin,
+			MaxAspect: *filterMaxAspect,
+			MinScore:  *filterMinScore,
+		},
+		Margin:     *margin,
+		Opacity:    *faceOpacity,
+		ColorMatch: *colorMatch,
+		Blend: faceutil.BlendOptions{
+			Mode:       blendMode,
+			Feather:    *featherWidth,
+			Iterations: *seamlessIter,
+		},
+		Modes:     modes,
+		Policy:    policy,
+		DrawFace:  *shouldDrawFace,
+		DrawRects: *shouldDrawRects,
+	}
+	if *seed != 0 {
+		opt.Rand = rand.NewSource(*seed)
+	}
+	return opt
+}
+
+// loadModes parses the -mode flag. The accessory pack is only loaded
+// when it's used.
+func loadModes() (faceutil.ModeRules, error) {
+	opt := faceutil.ModeOptions{
+		BlurSigma: *modeBlur,
+		Blocks:    *modeBlocks,
+	}
+	if *modeSticker != "" {
+		sticker, err := faceutil.OpenImage(*modeSticker)
+		if err != nil {
+			return nil, err
+		}
+		opt.Sticker = sticker
+	}
+	rules, err := faceutil.ParseModeRules(*modes, opt)
+	if err != nil || !rules.UsesAccessories() {
+		return rules, err
+	}
+	pack, err := faceutil.LoadAccessoryPack(*accessoryDir)
+	if err != nil {
+		return nil, err
+	}
+	opt.Accessories = pack
+	opt.AccessoryCount = *accessoryCount
+	return faceutil.ParseModeRules(*modes, opt)
+}
+
+// gifOptions maps the -gif flags onto the animation options
+func gifOptions() faceutil.GIFOptions {
+	return faceutil.GIFOptions{
+		Transition: gifTransition,
+		Frames:     *gifFrames,
+		Delay:      *gifDelay,
+		Hold:       *gifHold,
+		Colors:     *gifColors,
+		MaxSize:    *gifMaxSize,
+		MaxBytes:   *gifMaxBytes,
+	}
+}
+
+// exportOptions maps the -export flags onto the export options
+func exportOptions() faceutil.ExportOptions {
+	return faceutil.ExportOptions{
+		MinAspect:  *exportMinAspect,
+		MaxAspect:  *exportMaxAspect,
+		Fit:        exportFit,
+		Width:      *exportWidth,
+		MaxBytes:   *exportMaxBytes,
+		MinQuality: *exportMinQuality,
+		Quality:    *exportQuality,
+		Text:       textOptions(),
+	}
+}
+
+// textOptions maps the -text flags onto the text options
+func textOptions() faceutil.TextOptions {
+	return faceutil.TextOptions{
+		Style:    textStyle,
+		Position: textPosition,
+		MaxLines: *textLines,
+		MinSize:  *textMinSize,
+		MaxSize:  *textMaxSize,
+	}
+}
+
+// collageOptions maps the -collage flags onto the collage layout
+func collageOptions() faceutil.CollageOptions {
+	return faceutil.CollageOptions{
+		Size: *collageSize,
+		Gap:  *collageGap,
+	}
+}
+
+// storyOptions maps the -story flags onto the story layout
+func storyOptions() faceutil.StoryOptions {
+	return faceutil.StoryOptions{
+		Width:  *storyWidth,
+		Height: *storyHeight,
+	}
+}
+
+// trackOptions maps the -track flags onto the tracking options
+func trackOptions() faceutil.TrackOptions {
+	return faceutil.TrackOptions{
+		IoU:    *trackIoU,
+		MaxGap: *trackGap,
+		Smooth: *trackSmooth,
+	}
+}
+
+func parseAngles(s string) ([]float64, error) {
+	var angles []float64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		angle, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation: %s", f)
+		}
+		angles = append(angles, angle)
+	}
+	return angles, nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"image"
 	"image/jpeg"
 	_ "image/png"
 	"io"
@@ -10,6 +9,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -40,40 +40,130 @@ func readLines(filename string) ([]string, error) {
 	return captions, err
 }
 
-func testImage(imgfile string, w io.Writer) error {
-	f, err := os.Open(imgfile)
-	if err != nil {
-		return err
+func testImage(r *faceutil.Renderer, imgfile string, w io.Writer) error {
+	if strings.EqualFold(filepath.Ext(imgfile), ".gif") {
+		a, err := faceutil.OpenAnimation(imgfile)
+		if err != nil {
+			return err
+		}
+		if len(a.Frames) > 1 {
+			log.Debugf("found %d frame(s) in animation", len(a.Frames))
+			return faceutil.EncodeAnimation(w, r.ReplaceAnimation(a, trackOptions()))
+		}
 	}
-	defer f.Close()
-	baseImage, _, err := image.Decode(f)
+	baseImage, err := faceutil.OpenImage(imgfile)
 	if err != nil {
 		return err
 	}
-	faces := faceutil.DetectFaces(baseImage)
+	if *gifOutput {
+		return r.WriteGIF(w, baseImage, gifOptions())
+	}
+	faces, rejected := r.Detect(baseImage)
 	log.Debugf("found %d face(s) in image", len(faces))
-	newImage := faceutil.DrawFaces(baseImage, faces)
+	newImage := r.DrawRejections(r.Draw(baseImage, faces), rejected)
+	export := exportOptions()
+	if *storyOutput {
+		// test images don't have a poster to credit
+		opt := storyOptions()
+		if *storyCaption {
+			if opt.Caption, err = randomCaption(); err != nil {
+				return err
+			}
+		}
+		newImage = faceutil.Story(newImage, opt)
+		faces = nil
+		export.MinAspect, export.MaxAspect, export.Width = 0, 0, 0
+	}
+	if *textOutput && !*storyOutput {
+		caption, err := randomCaption()
+		if err != nil {
+			return err
+		}
+		if *exportOutput {
+			export.Caption = caption
+		} else {
+			newImage = r.DrawText(newImage, faces, caption, textOptions())
+		}
+	}
+	if *exportOutput {
+		return r.Export(w, newImage, faces, export)
+	}
 	return jpeg.Encode(w, newImage, &jpeg.Options{Quality: jpeg.DefaultQuality})
 }
 
-func testImageDir(dir string) error {
+// randomCaption returns a random line from captions.txt
+func randomCaption() (string, error) {
+	captions, err := readLines("captions.txt")
+	if err != nil {
+		return "", err
+	}
+	if len(captions) == 0 {
+		return "", nil
+	}
+	return captions[rand.Intn(len(captions))], nil
+}
+
+func testImageDir(r *faceutil.Renderer, dir string) error {
 	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return err
 	}
+	if *collageOutput {
+		return testCollage(r, dir, entries)
+	}
 	for _, e := range entries {
 		var (
 			srcFile = filepath.Join(dir, e.Name())
 			dstFile = filepath.Join(dir, "nick_"+e.Name())
 		)
+		if *gifOutput {
+			dstFile = strings.TrimSuffix(dstFile, filepath.Ext(dstFile)) + ".gif"
+		}
 		f, err := os.Create(dstFile)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		if err := testImage(srcFile, f); err != nil {
+		if err := testImage(r, srcFile, f); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// testCollage writes a collage of the first -collage.count images in
+// the directory to nick_collage.jpeg
+func testCollage(r *faceutil.Renderer, dir string, entries []os.FileInfo) error {
+	var (
+		photos   []faceutil.CollagePhoto
+		selector = r.NewSelector(nil)
+	)
+	for _, e := range entries {
+		if len(photos) == *collageCount {
+			break
+		}
+		if e.IsDir() || strings.HasPrefix(e.Name(), "nick_") {
+			continue
+		}
+		img, err := faceutil.OpenImage(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		faces, rejected := r.Detect(img)
+		log.Debugf("found %d face(s) in %s", len(faces), e.Name())
+		photos = append(photos, faceutil.CollagePhoto{
+			Image: r.DrawRejections(r.DrawWith(selector, img, faces), rejected),
+			Faces: faces,
+		})
+	}
+	f, err := os.Create(filepath.Join(dir, "nick_collage.jpeg"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	collage := r.Collage(photos, collageOptions())
+	if *exportOutput {
+		return r.Export(f, collage, nil, exportOptions())
+	}
+	return jpeg.Encode(f, collage, &jpeg.Options{Quality: jpeg.DefaultQuality})
+}